@@ -0,0 +1,87 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package database
+
+import (
+	"fmt"
+
+	"github.com/documize/community/core/env"
+	"github.com/pkg/errors"
+)
+
+// SchemaExists reports whether any Documize tables are present.
+func SchemaExists(runtime *env.Runtime) (bool, error) {
+	var tables []string
+	if err := runtime.Db.Select(&tables, runtime.StoreProvider.QueryTableList()); err != nil {
+		return false, err
+	}
+
+	return len(tables) > 0, nil
+}
+
+// Bootstrap creates initial schema for an empty database
+// using scripts written for the configured database provider.
+// Unlike InstallUpgrade, any failure is returned to the caller
+// so that boot stops before migrations run against a half-built schema.
+func Bootstrap(runtime *env.Runtime) (err error) {
+	return BootstrapVersion(runtime, 0)
+}
+
+// BootstrapVersion creates schema for an empty database up to and
+// including specified version, e.g. to match the schema a backup was taken from.
+// Version numbers are provider specific. Zero means latest version.
+func BootstrapVersion(runtime *env.Runtime, version int) (err error) {
+	exists, err := SchemaExists(runtime)
+	if err != nil {
+		return errors.Wrap(err, "unable to get database table list")
+	}
+	if exists {
+		return nil
+	}
+
+	scripts, err := LoadScripts(runtime)
+	if err != nil {
+		return errors.Wrap(err, "unable to load scripts")
+	}
+
+	toProcess := scriptsUpTo(SpecificScripts(runtime, scripts), version)
+	if len(toProcess) == 0 {
+		return fmt.Errorf("no schema scripts for database provider %s", runtime.StoreProvider.Type())
+	}
+
+	runtime.Log.Info(fmt.Sprintf("Database: creating schema for provider %s using %d scripts",
+		runtime.StoreProvider.Type(), len(toProcess)))
+
+	err = runScripts(runtime, toProcess)
+	if err != nil {
+		return errors.Wrap(err, "unable to create schema")
+	}
+
+	runtime.Log.Info("Database: schema created")
+
+	return nil
+}
+
+// Returns scripts up to and including version, all scripts for version zero.
+func scriptsUpTo(scripts []Script, version int) (s []Script) {
+	if version <= 0 {
+		return scripts
+	}
+
+	for _, script := range scripts {
+		if script.Version <= version {
+			s = append(s, script)
+		}
+	}
+
+	return
+}
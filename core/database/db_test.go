@@ -72,3 +72,16 @@ func TestParamRebind(t *testing.T) {
 
 	t.Log(test1)
 }
+
+func TestScriptsUpTo(t *testing.T) {
+	scripts := []Script{{Version: 1}, {Version: 2}, {Version: 3}}
+
+	if s := scriptsUpTo(scripts, 0); len(s) != 3 {
+		t.Errorf("expected 3 scripts got %d", len(s))
+	}
+
+	s := scriptsUpTo(scripts, 2)
+	if len(s) != 2 || s[1].Version != 2 {
+		t.Errorf("expected scripts 1 and 2 got %v", s)
+	}
+}
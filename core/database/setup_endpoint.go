@@ -91,7 +91,14 @@ func (h *Handler) Setup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = InstallUpgrade(h.Runtime, false); err != nil {
+	// Schema may already have been created during boot.
+	existingDB, err := SchemaExists(h.Runtime)
+	if err != nil {
+		h.Runtime.Log.Error("database.Setup table list", err)
+		return
+	}
+
+	if err = InstallUpgrade(h.Runtime, existingDB); err != nil {
 		h.Runtime.Log.Error("database.Setup migrate", err)
 		return
 	}
@@ -0,0 +1,44 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package env
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config file can set pool limits to zero while absent
+// settings are left for defaults to fill in.
+func TestConfigFlagsZero(t *testing.T) {
+	var ct ConfigToml
+	_, err := toml.Decode(`
+[database]
+maxIdleConns = 0
+maxOpenConns = 0
+connMaxLifetime = "0"
+connectRetries = 0
+`, &ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := configFlags(ct)
+	if f.DBMaxIdleConns != "0" || f.DBMaxOpenConns != "0" || f.DBConnMaxLifetime != "0" || f.DBConnectRetries != "0" {
+		t.Errorf("expected explicit zero settings got %+v", f)
+	}
+
+	f = configFlags(ConfigToml{})
+	if f.DBMaxIdleConns != "" || f.DBMaxOpenConns != "" || f.DBConnectRetries != "" {
+		t.Errorf("expected absent settings left blank got %+v", f)
+	}
+}
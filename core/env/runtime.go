@@ -44,6 +44,20 @@ type Runtime struct {
 	Log           Logger
 	Product       domain.Product
 	Assets        embed.FS
+
+	// Shutdown is cancelled once server has stopped serving requests.
+	// Long running work (e.g. backups) should stop when it is done.
+	Shutdown context.Context
+}
+
+// ShutdownDone returns channel closed on shutdown.
+// Channel is nil, and never closes, if runtime has no shutdown context.
+func (r *Runtime) ShutdownDone() <-chan struct{} {
+	if r.Shutdown == nil {
+		return nil
+	}
+
+	return r.Shutdown.Done()
 }
 
 // StartTx begins database transaction with given transaction isolation level.
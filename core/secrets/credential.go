@@ -0,0 +1,90 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CredentialProvider resolves secret values held outside of Documize,
+// such as Docker/Kubernetes secrets files or a secrets manager.
+type CredentialProvider interface {
+	// Resolve returns the secret value for the given provider specific path.
+	Resolve(path string) (string, error)
+}
+
+var credentialProviders = make(map[string]CredentialProvider)
+var credentialMutex sync.RWMutex
+
+// RegisterCredentialProvider makes provider available for references
+// of the form 'scheme:path'. Existing scheme registration is replaced.
+func RegisterCredentialProvider(scheme string, p CredentialProvider) {
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+
+	credentialProviders[strings.ToLower(scheme)] = p
+}
+
+// ResolveCredential returns secret value for reference of the form 'scheme:path',
+// for example 'file:/run/secrets/db_password' or 'env:DB_PASSWORD'.
+func ResolveCredential(ref string) (string, error) {
+	bits := strings.SplitN(ref, ":", 2)
+	if len(bits) != 2 || len(bits[1]) == 0 {
+		return "", fmt.Errorf("credential reference '%s' must be in the form scheme:path", ref)
+	}
+
+	credentialMutex.RLock()
+	p, ok := credentialProviders[strings.ToLower(bits[0])]
+	credentialMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("no credential provider registered for '%s'", bits[0])
+	}
+
+	return p.Resolve(bits[1])
+}
+
+// FileCredentialProvider reads secret from file, trimming trailing new lines.
+// Works with Docker/Kubernetes secrets and secrets manager agents
+// that write secrets to disk (e.g. Vault Agent).
+type FileCredentialProvider struct{}
+
+// Resolve reads secret from named file.
+func (p FileCredentialProvider) Resolve(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// EnvCredentialProvider reads secret from named environment variable.
+type EnvCredentialProvider struct{}
+
+// Resolve reads secret from named environment variable.
+func (p EnvCredentialProvider) Resolve(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", name)
+	}
+
+	return v, nil
+}
+
+func init() {
+	RegisterCredentialProvider("file", FileCredentialProvider{})
+	RegisterCredentialProvider("env", EnvCredentialProvider{})
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveCredentialFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "db_password")
+	if err := ioutil.WriteFile(fn, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := ResolveCredential("file:" + fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "s3cret" {
+		t.Errorf("expected s3cret got %s", v)
+	}
+}
+
+func TestResolveCredentialEnv(t *testing.T) {
+	os.Setenv("DOCUMIZE_TEST_DB_PASSWORD", "p@ss")
+	defer os.Unsetenv("DOCUMIZE_TEST_DB_PASSWORD")
+
+	v, err := ResolveCredential("env:DOCUMIZE_TEST_DB_PASSWORD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "p@ss" {
+		t.Errorf("expected p@ss got %s", v)
+	}
+}
+
+func TestResolveCredentialBadRef(t *testing.T) {
+	for _, ref := range []string{"", "file", "file:", "vault:secret/db"} {
+		if _, err := ResolveCredential(ref); err == nil {
+			t.Errorf("expected error for reference '%s'", ref)
+		}
+	}
+}
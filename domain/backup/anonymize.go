@@ -0,0 +1,133 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/documize/community/model/attachment"
+	"github.com/documize/community/model/audit"
+	m "github.com/documize/community/model/backup"
+	"github.com/documize/community/model/page"
+)
+
+// Placeholder for scrubbed document content.
+const scrubbedContent = "<p>Content removed.</p>"
+
+// Returns stable pseudonym for given ID so relationships
+// between anonymized records still hold.
+func pseudonym(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(h[:])[:10]
+}
+
+// Returns stable pseudonymous email address for given email.
+// Email is used as key so the same person maps to same address everywhere.
+func pseudonymEmail(email string) string {
+	if len(email) == 0 {
+		return ""
+	}
+
+	return "user-" + pseudonym(strings.ToLower(strings.TrimSpace(email))) + "@example.com"
+}
+
+// Replaces user names, email and credentials.
+func anonymizeUsers(u []m.User) {
+	for i := range u {
+		p := pseudonym(u[i].RefID)
+		u[i].Firstname = "User"
+		u[i].Lastname = p
+		u[i].Initials = "U" + strings.ToUpper(p[:1])
+		u[i].Email = pseudonymEmail(u[i].Email)
+		u[i].Password = ""
+		u[i].Salt = ""
+		u[i].Reset = ""
+	}
+}
+
+// Removes user credentials, leaving identity intact.
+func removeSecrets(u []m.User) {
+	for i := range u {
+		u[i].Password = ""
+		u[i].Salt = ""
+		u[i].Reset = ""
+	}
+}
+
+// Blanks organization sign-in configuration, license and subscription.
+func removeOrgSecrets(o []orgExtended) {
+	for i := range o {
+		o[i].AuthConfig = ""
+		o[i].Serial = ""
+		o[i].Subscription = ""
+	}
+}
+
+// Removes IP addresses from audit trail.
+func anonymizeAudit(al []audit.AppEvent) {
+	for i := range al {
+		al[i].IP = ""
+	}
+}
+
+// Replaces commenter email.
+func anonymizeComments(cm []comment) {
+	for i := range cm {
+		cm[i].Email = pseudonymEmail(cm[i].Email)
+	}
+}
+
+// Replaces share recipient email and personal message.
+func anonymizeShares(sh []share) {
+	for i := range sh {
+		sh[i].Email = pseudonymEmail(sh[i].Email)
+		sh[i].Message = ""
+	}
+}
+
+// Replaces section body.
+func scrubPages(p []page.Page) {
+	for i := range p {
+		p[i].Body = scrubbedContent
+	}
+}
+
+// Removes section raw data used by section providers.
+func scrubPageMeta(pm []page.Meta) {
+	for i := range pm {
+		pm[i].RawBody = ""
+	}
+}
+
+// Replaces section revision body and raw data.
+func scrubRevisions(r []page.Revision) {
+	for i := range r {
+		r[i].Body = scrubbedContent
+		r[i].RawBody = ""
+	}
+}
+
+// Replaces comment text.
+func scrubComments(cm []comment) {
+	for i := range cm {
+		cm[i].Feedback = "Comment removed."
+	}
+}
+
+// Removes attachment file content.
+func scrubAttachments(at []attachment.Attachment) {
+	for i := range at {
+		at[i].Data = []byte{}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"testing"
+
+	m "github.com/documize/community/model/backup"
+)
+
+// go test github.com/documize/community/domain/backup -run TestAnonymize
+func TestAnonymizeUsers(t *testing.T) {
+	u := []m.User{
+		{Firstname: "Jane", Lastname: "Doe", Email: "jane@example.org", Password: "hash", Salt: "salt"},
+		{Firstname: "John", Lastname: "Roe", Email: "john@example.org"},
+	}
+	u[0].RefID = "abc"
+	u[1].RefID = "def"
+
+	anonymizeUsers(u)
+
+	if u[0].Firstname == "Jane" || u[0].Lastname == "Doe" || u[0].Email == "jane@example.org" {
+		t.Errorf("expected user to be anonymized got %+v", u[0])
+	}
+	if u[0].Password != "" || u[0].Salt != "" {
+		t.Error("expected credentials to be removed")
+	}
+	if u[0].Email == u[1].Email {
+		t.Error("expected distinct pseudonyms for distinct users")
+	}
+
+	// Same email anywhere in backup maps to same pseudonym.
+	if u[0].Email != pseudonymEmail("Jane@Example.org") {
+		t.Errorf("expected stable pseudonym got %s", u[0].Email)
+	}
+}
+
+func TestPseudonymEmpty(t *testing.T) {
+	if e := pseudonymEmail(""); e != "" {
+		t.Errorf("expected empty email got %s", e)
+	}
+}
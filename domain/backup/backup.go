@@ -16,21 +16,26 @@ package backup
 // current organization (tenant).
 //
 // Selected data is marshalled to JSON format and then zipped up
-// into a single file on the server. The resultant file is then sent
-// to the caller (e.g. web browser) as a file download. Unless specified,
+// straight into the response sent to the caller (e.g. web browser)
+// as a file download. Backups that are retained, verified or split
+// are written to a file on the server first. Unless specified,
 // the file is deleted at the end of the process.
 //
 // The backup file contains a manifest file that describes the backup.
-//
-// TODO: explore writing straight to HTTP response via https://github.com/mholt/archiver
 
 import (
 	"archive/zip"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/documize/community/core/database"
 	"github.com/documize/community/core/env"
 	"github.com/documize/community/core/uniqueid"
 	"github.com/documize/community/domain"
@@ -61,8 +66,20 @@ type backerHandler struct {
 	Store   *store.Store
 	Spec    m.ExportSpec
 	Context domain.RequestContext
+
+	// Optional listener for per table progress.
+	Progress *progressFeed
+
+	// Optional channel closed when backup should be abandoned,
+	// e.g. server shutting down or caller gone away.
+	Cancel <-chan struct{}
 }
 
+var errBackupCancelled = errors.New("backup cancelled")
+
+// Timestamp layout understood by all supported database providers.
+const sqlTimeFormat = "2006-01-02 15:04:05"
+
 // Represents backup file.
 type backupItem struct {
 	Filename, Content string
@@ -78,156 +95,527 @@ func toJSON(v interface{}) (string, error) {
 	return string(j), nil
 }
 
-// GenerateBackup produces ZIP file of specified content.GenerateBackup
-// File is located at the same location as the running program.
-// NOTE: it is up to the caller to remove the file from disk.
-func (b backerHandler) GenerateBackup() (filename string, err error) {
+// Returns unique backup ID.
+func backupID() string {
 	// As precaution we first generate short string first.
 	var id = uniqueid.Generate()
 	newUUID, err := uuid.NewV4()
 	if err == nil {
 		id = newUUID.String()
 	}
-	filename = fmt.Sprintf("dmz-backup-%s.zip", id)
+
+	return id
+}
+
+// Returns backup filename for given backup ID.
+func backupFilename(id string) string {
+	return fmt.Sprintf("dmz-backup-%s.zip", id)
+}
+
+// GenerateBackup produces ZIP file of specified content.GenerateBackup
+// File is located in the configured backup temp folder.
+// Split backups return filename of part manifest.
+// NOTE: it is up to the caller to remove the file from disk.
+func (b backerHandler) GenerateBackup() (filename string, err error) {
+	defer func() { b.Progress.finish(err) }()
+
+	err = validateSpec(b.Spec)
+	if err != nil {
+		return
+	}
+
+	// Passphrase may be held by credential provider.
+	passphrase, err := resolvePassphrase(b.Spec.Passphrase, b.Spec.PassphraseRef)
+	if err != nil {
+		return
+	}
+
+	id := backupID()
+	filename = filepath.Join(b.Runtime.Flags.BackupTempDir, backupFilename(id))
 
 	bf, err := os.Create(filename)
 	if err != nil {
 		return
 	}
-	defer bf.Close()
+	// Partial backup files are of no use to anybody.
+	defer func() {
+		bf.Close()
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
+
+	_, _, err = b.writeArchive(bf, id)
+	if err != nil {
+		return
+	}
+
+	// Optionally check what we wrote against the database before anyone relies on it.
+	if b.Spec.Verify {
+		bf.Close()
+		err = b.verify(filename)
+		if err != nil {
+			return filename, errors.Wrap(err, "backup verification failed")
+		}
+		b.Runtime.Log.Info("Backup verification passed")
+	}
+
+	// Optionally encrypt, verification has already read back plain archive.
+	if len(passphrase) > 0 {
+		bf.Close()
+		encrypted, e2 := encryptFile(filename, passphrase)
+		if e2 != nil {
+			err = errors.Wrap(e2, "unable to encrypt backup")
+			return
+		}
+		filename = encrypted
+	}
+
+	// Optionally break archive into parts, returning part manifest instead.
+	if b.Spec.SplitSizeBytes > 0 {
+		bf.Close()
+		index, e2 := splitArchive(filename, id, b.Spec.SplitSizeBytes)
+		if e2 != nil {
+			err = errors.Wrap(e2, "unable to split backup")
+			return
+		}
+		filename = index
+	}
+
+	return filename, nil
+}
+
+// StreamBackup writes ZIP file of specified content to w
+// without keeping a copy on disk.
+// Spec must have been validated beforehand.
+// Written reports bytes that reached w, if any, before failure.
+func (b backerHandler) StreamBackup(w io.Writer, id string) (written int64, err error) {
+	defer func() { b.Progress.finish(err) }()
+
+	_, written, err = b.writeArchive(w, id)
+	return
+}
+
+// Writes backup archive to w, returning files written and archive size.
+func (b backerHandler) writeArchive(w io.Writer, id string) (files []backupItem, size int64, err error) {
+	// Scope IDs are written into queries.
+	err = checkScopeIDs(b.Spec)
+	if err != nil {
+		return
+	}
 
-	// Create a zip writer on the file write
-	zw := zip.NewWriter(bf)
+	// Row totals up front let us size backup before reading any data.
+	if b.Store != nil && b.Store.Backup != nil {
+		counts, e2 := b.Store.Backup.CountRows(b.Context, b.Spec)
+		if e2 != nil {
+			err = e2
+			return
+		}
+		total := 0
+		for _, n := range counts {
+			total += n
+		}
+		b.Runtime.Log.Info(fmt.Sprintf("Backup will export %d rows from %d tables", total, len(counts)))
+	}
 
 	// Get the files to write to the ZIP file.
-	files, err := b.produce(id)
+	files, err = b.produce(id)
 	if err != nil {
-		return filename, err
+		return
 	}
 
-	// Write backup data to zip file on disk.
+	size, err = writeZip(w, files, b.Spec.MaxArchiveBytes, b.Spec.IsExcluded)
+
+	return
+}
+
+// Writes backup files as zip archive to w, leaving out excluded tables.
+// Returns bytes written to w, which fails once limit is exceeded.
+func writeZip(w io.Writer, files []backupItem, limit int64, excluded func(table string) bool) (size int64, err error) {
+	// Create a zip writer on the destination, keeping track of size.
+	lw := &limitWriter{w: w, limit: limit}
+	defer func() { size = lw.n }()
+	zw := zip.NewWriter(lw)
+
 	for _, file := range files {
+		// Excluded tables are recorded in manifest rather than written as empty.
+		if excluded(strings.TrimSuffix(file.Filename, ".json")) {
+			continue
+		}
+
 		fileWriter, e2 := zw.Create(file.Filename)
 		if e2 != nil {
-			return filename, e2
+			return 0, e2
 		}
 		_, e2 = fileWriter.Write([]byte(file.Content))
-		if err != nil {
-			return filename, e2
+		if e2 != nil {
+			return 0, e2
 		}
 	}
 
 	// Close out process.
 	err = zw.Close()
+
+	return
+}
+
+// Verify checks backup file against the database: every table counted
+// for the backup scope must decode from the archive with as many rows
+// as the database holds. Rows written while backup runs show up as
+// mismatches, which is the safe way round.
+func (b backerHandler) verify(filename string) (err error) {
+	counts, err := b.Store.Backup.CountRows(b.Context, b.Spec)
 	if err != nil {
 		return
 	}
 
-	return filename, nil
+	return checkArchiveCounts(filename, counts)
+}
+
+// Checks archive holds expected number of rows per table.
+func checkArchiveCounts(filename string, counts map[string]int) (err error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return errors.Wrap(err, "cannot open backup file")
+	}
+	defer zr.Close()
+
+	archived := make(map[string]*zip.File)
+	for _, zf := range zr.File {
+		archived[zf.Name] = zf
+	}
+
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		name := table + ".json"
+		zf, ok := archived[name]
+		if !ok {
+			return fmt.Errorf("%s missing from backup file", name)
+		}
+		rc, e := zf.Open()
+		if e != nil {
+			return errors.Wrap(e, fmt.Sprintf("cannot open %s", name))
+		}
+		content, e := ioutil.ReadAll(rc)
+		rc.Close()
+		if e != nil {
+			return errors.Wrap(e, fmt.Sprintf("cannot read %s", name))
+		}
+
+		got, e := countRows(content)
+		if e != nil {
+			return errors.Wrap(e, fmt.Sprintf("cannot decode %s", name))
+		}
+		if got != counts[table] {
+			return fmt.Errorf("%s has %d rows, database has %d", name, got, counts[table])
+		}
+	}
+
+	return nil
+}
+
+// Returns number of rows held in table JSON array.
+func countRows(content []byte) (int, error) {
+	rows := []json.RawMessage{}
+	err := json.Unmarshal(content, &rows)
+
+	return len(rows), err
+}
+
+// Tracks bytes written to backup file and fails once limit is exceeded.
+// Zero limit means no limit.
+type limitWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.limit > 0 && l.n+int64(len(p)) > l.limit {
+		return 0, fmt.Errorf("backup exceeds maximum archive size of %d bytes", l.limit)
+	}
+
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+
+	return n, err
 }
 
 // Produce collection of files to be included in backup file.
 func (b backerHandler) produce(id string) (files []backupItem, err error) {
+	steps := []func(*[]backupItem) error{
+		b.dmzOrg,         // Organization
+		b.dmzConfig,      // Config, User Config (not for space backup)
+		b.dmzUserAccount, // User, Account
+		b.dmzGroup,       // Group, Member
+		b.dmzActivity,    // Activity, Audit (not for space backup)
+		b.dmzPin,         // Pin
+		b.dmzSpaceLabel,  // Space Label
+		b.dmzSpace,       // Space, Permission
+		b.dmzCategory,    // Category, Category Member
+		b.dmzSection,     // Section, Section Meta, Section Revision, Section Template
+		b.dmzDocument,    // Document, Link, Vote, Comment, Share, Attachment
+		b.dmzAction,      // Action
+	}
+
+	b.Progress.start(b.tableCount())
+
+	for _, step := range steps {
+		select {
+		case <-b.Cancel:
+			return files, errBackupCancelled
+		default:
+		}
+
+		reported := len(files)
+
+		err = step(&files)
+		if err != nil {
+			return
+		}
+
+		for _, file := range files[reported:] {
+			table := strings.TrimSuffix(file.Filename, ".json")
+			if !b.Spec.IsExcluded(table) {
+				n, _ := countRows([]byte(file.Content))
+				b.Progress.table(table, n)
+			}
+		}
+	}
+
 	// Backup manifest
-	c, err := b.manifest(id)
+	c, err := b.manifest(id, files)
 	if err != nil {
 		return
 	}
-	files = append(files, backupItem{Filename: "manifest.json", Content: c})
+	files = append([]backupItem{{Filename: "manifest.json", Content: c}}, files...)
 
-	// Organization
-	err = b.dmzOrg(&files)
-	if err != nil {
-		return
+	return
+}
+
+// Returns number of tables backup exports.
+func (b backerHandler) tableCount() (n int) {
+	for _, ts := range countScopes(b.Spec) {
+		if !b.Spec.IsExcluded(ts.table) {
+			n++
+		}
 	}
 
-	// Config, User Config
-	err = b.dmzConfig(&files)
-	if err != nil {
-		return
+	return
+}
+
+// Runs table query unless table is excluded from backup.
+func (b backerHandler) query(table string, dest interface{}, query string) error {
+	if b.Spec.IsExcluded(table) {
+		return nil
 	}
 
-	// User, Account
-	err = b.dmzUserAccount(&files)
-	if err != nil {
-		return
+	return b.Runtime.Db.Select(dest, query)
+}
+
+// Restricts query to documents created or revised within
+// requested date range and held in requested space, if any.
+// Column identifies document.
+func (b backerHandler) docScope(where, column string) string {
+	var c []string
+
+	// Document was created or revised inside the window.
+	if b.Spec.DateBounded() {
+		c = append(c, fmt.Sprintf("((%s) OR (%s))", b.dateBound("c_created"), b.dateBound("c_revised")))
+	}
+	if b.Spec.SpaceBackup() {
+		c = append(c, fmt.Sprintf("c_spaceid='%s'", b.Spec.SpaceID))
+	}
+	if len(c) == 0 {
+		return where
 	}
 
-	// Group, Member
-	err = b.dmzGroup(&files)
-	if err != nil {
-		return
+	cond := strings.Join(c, " AND ")
+
+	// Dependent rows are selected by owning document regardless of their own dates.
+	if column != "c_refid" {
+		cond = fmt.Sprintf("%s IN (SELECT c_refid FROM dmz_doc WHERE %s)", column, cond)
 	}
 
-	// Activity, Audit
-	err = b.dmzActivity(&files)
-	if err != nil {
-		return
+	return addCondition(where, cond)
+}
+
+// Restricts query to rows belonging to requested space, if any.
+// Column identifies space.
+func (b backerHandler) spaceScope(where, column string) string {
+	if !b.Spec.SpaceBackup() {
+		return where
 	}
 
-	// Pin
-	err = b.dmzPin(&files)
-	if err != nil {
-		return
+	return addCondition(where, fmt.Sprintf("%s='%s'", column, b.Spec.SpaceID))
+}
+
+// Restricts query to rows that belong to documents in requested space, if any.
+// Column identifies document.
+func (b backerHandler) spaceDocScope(where, column string) string {
+	if !b.Spec.SpaceBackup() {
+		return where
 	}
 
-	// Space Label
-	err = b.dmzSpaceLabel(&files)
-	if err != nil {
-		return
+	return addCondition(where, fmt.Sprintf("%s IN (SELECT c_refid FROM dmz_doc WHERE c_spaceid='%s')", column, b.Spec.SpaceID))
+}
+
+// Restricts permissions to those granted on requested space,
+// its categories and documents, if any.
+func (b backerHandler) permissionScope(where string) string {
+	if !b.Spec.SpaceBackup() {
+		return where
 	}
 
-	// Space, Permission.
-	err = b.dmzSpace(&files)
-	if err != nil {
-		return
+	return addCondition(where, b.grantedOnSpace())
+}
+
+// Restricts query to users granted access to requested space,
+// directly or through group membership, if any.
+// Column identifies user.
+func (b backerHandler) memberScope(where, column string) string {
+	if !b.Spec.SpaceBackup() {
+		return where
 	}
 
-	// Category, Category Member.
-	err = b.dmzCategory(&files)
-	if err != nil {
-		return
+	return addCondition(where, fmt.Sprintf(
+		"(%[1]s IN (SELECT c_whoid FROM dmz_permission WHERE c_who='user' AND %[2]s) OR %[1]s IN (SELECT c_userid FROM dmz_group_member WHERE c_groupid IN (SELECT c_whoid FROM dmz_permission WHERE c_who='role' AND %[2]s)))",
+		column, b.grantedOnSpace()))
+}
+
+// Restricts query to groups granted access to requested space, if any.
+// Column identifies group.
+func (b backerHandler) groupScope(where, column string) string {
+	if !b.Spec.SpaceBackup() {
+		return where
 	}
 
-	// Section, Section Meta, Section Revision, Section Template.
-	err = b.dmzSection(&files)
-	if err != nil {
-		return
+	return addCondition(where, fmt.Sprintf("%s IN (SELECT c_whoid FROM dmz_permission WHERE c_who='role' AND %s)",
+		column, b.grantedOnSpace()))
+}
+
+// Restricts space labels to the one used by requested space, if any.
+func (b backerHandler) labelScope(where string) string {
+	if !b.Spec.SpaceBackup() {
+		return where
 	}
 
-	// Document, Link, Vote, Comment, Share, Attachment.
-	err = b.dmzDocument(&files)
+	return addCondition(where, fmt.Sprintf("c_refid IN (SELECT c_labelid FROM dmz_space WHERE c_refid='%s')", b.Spec.SpaceID))
+}
+
+// Matches permissions granted on requested space, its categories and documents.
+func (b backerHandler) grantedOnSpace() string {
+	return fmt.Sprintf(
+		"(c_refid='%[1]s' OR c_refid IN (SELECT c_refid FROM dmz_category WHERE c_spaceid='%[1]s') OR c_refid IN (SELECT c_refid FROM dmz_doc WHERE c_spaceid='%[1]s'))",
+		b.Spec.SpaceID)
+}
+
+// Restricts query to rows whose timestamp column falls within
+// requested date range, if any.
+func (b backerHandler) dateScope(where, column string) string {
+	if !b.Spec.DateBounded() {
+		return where
+	}
+
+	return addCondition(where, b.dateBound(column))
+}
+
+// Returns date range condition for given column.
+func (b backerHandler) dateBound(column string) string {
+	var c []string
+	if !b.Spec.DateFrom.IsZero() {
+		c = append(c, fmt.Sprintf("%s >= '%s'", column, b.Spec.DateFrom.UTC().Format(sqlTimeFormat)))
+	}
+	if !b.Spec.DateTo.IsZero() {
+		c = append(c, fmt.Sprintf("%s <= '%s'", column, b.Spec.DateTo.UTC().Format(sqlTimeFormat)))
+	}
+
+	return strings.Join(c, " AND ")
+}
+
+// Appends condition to optional WHERE clause.
+func addCondition(where, cond string) string {
+	if len(strings.TrimSpace(where)) == 0 {
+		return fmt.Sprintf(" WHERE %s ", cond)
+	}
+
+	return fmt.Sprintf("%s AND %s ", where, cond)
+}
+
+// Manifest describes envrionement of backup source.
+func (b backerHandler) manifest(id string, files []backupItem) (string, error) {
+	mf, err := newManifest(id, b.Spec, files)
 	if err != nil {
-		return
+		return "", err
 	}
 
-	// Action
-	err = b.dmzAction(&files)
+	// Older databases may not report version, restore then assumes latest.
+	version, err := database.CurrentVersion(b.Runtime)
 	if err != nil {
-		return
+		b.Runtime.Log.Infof("Backup unable to get database version: %s", err.Error())
 	}
 
-	return
+	mf.Edition = b.Runtime.Product.Edition
+	mf.Version = b.Runtime.Product.Version
+	mf.Major = b.Runtime.Product.Major
+	mf.Minor = b.Runtime.Product.Minor
+	mf.Patch = b.Runtime.Product.Patch
+	mf.Revision = b.Runtime.Product.Revision
+	mf.StoreType = b.Runtime.StoreProvider.Type()
+	mf.SchemaVersion = version
+
+	return toJSON(mf)
 }
 
-// Manifest describes envrionement of backup source.
-func (b backerHandler) manifest(id string) (string, error) {
-	m := m.Manifest{
-		ID:        id,
-		Edition:   b.Runtime.Product.Edition,
-		Version:   b.Runtime.Product.Version,
-		Major:     b.Runtime.Product.Major,
-		Minor:     b.Runtime.Product.Minor,
-		Patch:     b.Runtime.Product.Patch,
-		Revision:  b.Runtime.Product.Revision,
-		StoreType: b.Runtime.StoreProvider.Type(),
-		Created:   time.Now().UTC(),
-		OrgID:     b.Spec.OrgID,
+// newManifest describes backup scope and archive contents.
+// Row counts, sizes and checksums let restore verify everything landed.
+func newManifest(id string, spec m.ExportSpec, files []backupItem) (mf m.Manifest, err error) {
+	// Secrets are never echoed.
+	spec.Passphrase = ""
+	if spec.Destination != nil {
+		d := *spec.Destination
+		d.AccessKey, d.SecretKey = "", ""
+		spec.Destination = &d
+	}
+
+	mf = m.Manifest{
+		ID:       id,
+		Created:  time.Now().UTC(),
+		OrgID:    spec.OrgID,
+		Excluded: spec.Excluded(),
+		Labels:   spec.Labels,
+		Counts:   make(map[string]int),
+		DateFrom: spec.DateFrom,
+		DateTo:   spec.DateTo,
+		SpaceID:  spec.SpaceID,
+		Spec:     &spec,
 	}
 
-	s, err := toJSON(m)
+	for _, file := range files {
+		table := strings.TrimSuffix(file.Filename, ".json")
 
-	return s, err
+		n, e := countRows([]byte(file.Content))
+		if e != nil {
+			return mf, errors.Wrap(e, fmt.Sprintf("cannot count rows for %s", file.Filename))
+		}
+		mf.Counts[table] = n
+
+		// Excluded tables are not written to archive.
+		if !spec.IsExcluded(table) {
+			mf.Files = append(mf.Files, m.ManifestFile{
+				Name:     file.Filename,
+				Size:     int64(len(file.Content)),
+				Checksum: archiveChecksum([]byte(file.Content)),
+			})
+		}
+	}
+
+	return
 }
 
 // Organization.
@@ -238,7 +626,7 @@ func (b backerHandler) dmzOrg(files *[]backupItem) (err error) {
 	}
 
 	o := []orgExtended{}
-	err = b.Runtime.Db.Select(&o, `SELECT id, c_refid AS refid,
+	err = b.query("dmz_org", &o, `SELECT id, c_refid AS refid,
         c_title AS title, c_message AS message, c_domain AS domain,
         c_service AS conversionendpoint, c_email AS email, c_serial AS serial, c_active AS active,
         c_anonaccess AS allowanonymousaccess, c_authprovider AS authprovider,
@@ -249,6 +637,10 @@ func (b backerHandler) dmzOrg(files *[]backupItem) (err error) {
 	if err != nil {
 		return
 	}
+	// Space owners must not walk away with sign-in configuration or license.
+	if b.Spec.SpaceBackup() {
+		removeOrgSecrets(o)
+	}
 
 	content, err := toJSON(o)
 	if err != nil {
@@ -261,8 +653,13 @@ func (b backerHandler) dmzOrg(files *[]backupItem) (err error) {
 
 // Config, User Config.
 func (b backerHandler) dmzConfig(files *[]backupItem) (err error) {
+	// Both are organization or system wide.
+	if b.Spec.SpaceBackup() {
+		return nil
+	}
+
 	c := []config{}
-	err = b.Runtime.Db.Select(&c, `SELECT c_key AS configkey, c_config AS configvalue FROM dmz_config`)
+	err = b.query("dmz_config", &c, `SELECT c_key AS configkey, c_config AS configvalue FROM dmz_config`)
 	if err != nil {
 		return
 	}
@@ -282,7 +679,7 @@ func (b backerHandler) dmzConfig(files *[]backupItem) (err error) {
 	}
 
 	uc := []userConfig{}
-	err = b.Runtime.Db.Select(&uc, `select c_orgid AS orgid, c_userid AS userid,
+	err = b.query("dmz_user_config", &uc, `select c_orgid AS orgid, c_userid AS userid,
 	c_key AS configkey, c_config AS configvalue FROM dmz_user_config`+w)
 	if err != nil {
 		return
@@ -305,15 +702,22 @@ func (b backerHandler) dmzUserAccount(files *[]backupItem) (err error) {
 	}
 
 	u := []m.User{}
-	err = b.Runtime.Db.Select(&u, `SELECT u.id, u.c_refid AS refid,
+	err = b.query("dmz_user", &u, `SELECT u.id, u.c_refid AS refid,
         u.c_firstname AS firstname, u.c_lastname AS lastname, u.c_email AS email,
         u.c_initials AS initials, u.c_globaladmin AS globaladmin,
         u.c_password AS password, u.c_salt AS salt, u.c_reset AS reset, u.c_lastversion AS lastversion, u.c_locale as locale,
         u.c_created AS created, u.c_revised AS revised
-        FROM dmz_user u`+w)
+        FROM dmz_user u`+b.memberScope(w, "u.c_refid"))
 	if err != nil {
 		return
 	}
+	if b.Spec.Anonymize {
+		anonymizeUsers(u)
+	}
+	// Space owners must not walk away with password hashes.
+	if b.Spec.SpaceBackup() {
+		removeSecrets(u)
+	}
 
 	content, err := toJSON(u)
 	if err != nil {
@@ -326,10 +730,10 @@ func (b backerHandler) dmzUserAccount(files *[]backupItem) (err error) {
 		w = fmt.Sprintf(" WHERE c_orgid='%s' ", b.Spec.OrgID)
 	}
 	acc := []account.Account{}
-	err = b.Runtime.Db.Select(&acc, `SELECT id, c_refid AS refid, c_orgid AS orgid, c_userid AS userid,
+	err = b.query("dmz_user_account", &acc, `SELECT id, c_refid AS refid, c_orgid AS orgid, c_userid AS userid,
 	c_editor AS editor, c_admin AS admin, c_users AS users, c_analytics AS analytics,
 	c_active AS active, c_created AS created, c_revised AS revised
-	FROM dmz_user_account`+w)
+	FROM dmz_user_account`+b.memberScope(w, "c_userid"))
 	if err != nil {
 		return
 	}
@@ -351,11 +755,11 @@ func (b backerHandler) dmzGroup(files *[]backupItem) (err error) {
 	}
 
 	g := []group.Group{}
-	err = b.Runtime.Db.Select(&g, `
+	err = b.query("dmz_group", &g, `
         SELECT id, c_refid AS refid,
         c_orgid AS orgid, c_name AS name, c_desc AS purpose,
         c_created AS created, c_revised AS revised
-        FROM dmz_group`+w)
+        FROM dmz_group`+b.groupScope(w, "c_refid"))
 	if err != nil {
 		return
 	}
@@ -371,9 +775,9 @@ func (b backerHandler) dmzGroup(files *[]backupItem) (err error) {
 		w = fmt.Sprintf(" WHERE c_orgid='%s' ", b.Spec.OrgID)
 	}
 	gm := []group.Member{}
-	err = b.Runtime.Db.Select(&gm, `
+	err = b.query("dmz_group_member", &gm, `
         SELECT id, c_orgid AS orgid, c_groupid AS groupid, c_userid AS userid
-        FROM dmz_group_member`+w)
+        FROM dmz_group_member`+b.groupScope(w, "c_groupid"))
 	if err != nil {
 		return
 	}
@@ -395,11 +799,11 @@ func (b backerHandler) dmzActivity(files *[]backupItem) (err error) {
 	}
 
 	ac := []activity.UserActivity{}
-	err = b.Runtime.Db.Select(&ac, `
+	err = b.query("dmz_user_activity", &ac, `
         SELECT id, c_orgid AS orgid, c_userid AS userid, c_spaceid AS spaceid,
         c_docid AS documentid, c_sectionid AS sectionid, c_sourcetype AS sourcetype,
         c_activitytype AS activitytype, c_metadata AS metadata, c_created AS created
-        FROM dmz_user_activity`+w)
+        FROM dmz_user_activity`+b.spaceScope(w, "c_spaceid"))
 	if err != nil {
 		return errors.Wrap(err, "select.activity")
 	}
@@ -410,20 +814,30 @@ func (b backerHandler) dmzActivity(files *[]backupItem) (err error) {
 	}
 	*files = append(*files, backupItem{Filename: "dmz_user_activity.json", Content: content})
 
+	// Audit log can be large so it is only included on request,
+	// and never for space backups as it covers the whole organization.
+	if !b.Spec.IncludeAudit || b.Spec.SpaceBackup() {
+		return
+	}
+
 	w = ""
 	if !b.Spec.SystemBackup() {
 		w = fmt.Sprintf(" WHERE c_orgid='%s' ", b.Spec.OrgID)
 	}
 
 	al := []audit.AppEvent{}
-	err = b.Runtime.Db.Select(&al, `
+	err = b.query("dmz_audit_log", &al, `
         SELECT c_orgid AS orgid, c_userid AS userid, c_eventtype AS type,
         c_ip AS ip, c_created AS created
-        FROM dmz_audit_log`+w)
+        FROM dmz_audit_log`+b.dateScope(w, "c_created"))
 	if err != nil {
 		return errors.Wrap(err, "select.audit")
 	}
 
+	if b.Spec.Anonymize {
+		anonymizeAudit(al)
+	}
+
 	content, err = toJSON(al)
 	if err != nil {
 		return errors.Wrap(err, "json.audit")
@@ -441,11 +855,11 @@ func (b backerHandler) dmzPin(files *[]backupItem) (err error) {
 	}
 
 	p := []pin.Pin{}
-	err = b.Runtime.Db.Select(&p, `
+	err = b.query("dmz_pin", &p, `
         SELECT id, c_refid AS refid,
         c_orgid AS orgid, c_userid AS userid, c_spaceid AS spaceid, c_docid AS documentid,
         c_name AS name, c_sequence AS sequence, c_created AS created, c_revised AS revised
-        FROM dmz_pin`+w)
+        FROM dmz_pin`+b.spaceScope(w, "c_spaceid"))
 	if err != nil {
 		return errors.Wrap(err, "select.pin")
 	}
@@ -467,11 +881,11 @@ func (b backerHandler) dmzSpaceLabel(files *[]backupItem) (err error) {
 	}
 
 	l := []label.Label{}
-	err = b.Runtime.Db.Select(&l, `
+	err = b.query("dmz_space_label", &l, `
         SELECT id, c_refid AS refid,
         c_orgid AS orgid, c_name AS name, c_color AS color,
         c_created AS created, c_revised AS revised
-        FROM dmz_space_label`+w)
+        FROM dmz_space_label`+b.labelScope(w))
 	if err != nil {
 		return errors.Wrap(err, "select.space_label")
 	}
@@ -493,13 +907,13 @@ func (b backerHandler) dmzSpace(files *[]backupItem) (err error) {
 	}
 
 	sp := []space.Space{}
-	err = b.Runtime.Db.Select(&sp, `SELECT id, c_refid AS refid,
+	err = b.query("dmz_space", &sp, `SELECT id, c_refid AS refid,
         c_name AS name, c_orgid AS orgid, c_userid AS userid,
         c_type AS type, c_lifecycle AS lifecycle, c_likes AS likes,
         c_icon AS icon, c_labelid AS labelid, c_desc AS description,
         c_count_category As countcategory, c_count_content AS countcontent,
         c_created AS created, c_revised AS revised
-        FROM dmz_space`+w)
+        FROM dmz_space`+b.spaceScope(w, "c_refid"))
 	if err != nil {
 		return
 	}
@@ -516,11 +930,11 @@ func (b backerHandler) dmzSpace(files *[]backupItem) (err error) {
 	}
 
 	p := []permission.Permission{}
-	err = b.Runtime.Db.Select(&p, `
+	err = b.query("dmz_permission", &p, `
         SELECT id, c_orgid AS orgid, c_who AS who, c_whoid AS whoid,
         c_action AS action, c_scope AS scope, c_location AS location,
         c_refid AS refid, c_created AS created
-        FROM dmz_permission`+w)
+        FROM dmz_permission`+b.permissionScope(w))
 	if err != nil {
 		return errors.Wrap(err, "select.permission")
 	}
@@ -542,12 +956,12 @@ func (b backerHandler) dmzCategory(files *[]backupItem) (err error) {
 	}
 
 	cat := []category.Category{}
-	err = b.Runtime.Db.Select(&cat, `
+	err = b.query("dmz_category", &cat, `
         SELECT id, c_refid AS refid,
         c_orgid AS orgid, c_spaceid AS spaceid,
 		c_name AS name, c_default AS isdefault,
 		c_created AS created, c_revised AS revised
-        FROM dmz_category`+w)
+        FROM dmz_category`+b.spaceScope(w, "c_spaceid"))
 	if err != nil {
 		return errors.Wrap(err, "select.category")
 	}
@@ -564,11 +978,11 @@ func (b backerHandler) dmzCategory(files *[]backupItem) (err error) {
 	}
 
 	cm := []category.Member{}
-	err = b.Runtime.Db.Select(&cm, `
+	err = b.query("dmz_category_member", &cm, `
         SELECT id, c_refid AS refid, c_orgid AS orgid,
         c_spaceid AS spaceid, c_categoryid AS categoryid,
         c_docid AS documentid, c_created AS created, c_revised AS revised
-        FROM dmz_category_member`+w)
+        FROM dmz_category_member`+b.spaceScope(w, "c_spaceid"))
 	if err != nil {
 		return errors.Wrap(err, "select.categorymember")
 	}
@@ -591,15 +1005,19 @@ func (b backerHandler) dmzSection(files *[]backupItem) (err error) {
 
 	// Section
 	sec := []page.Page{}
-	err = b.Runtime.Db.Select(&sec, `
+	err = b.query("dmz_section", &sec, `
         SELECT id, c_refid AS refid, c_orgid AS orgid, c_docid AS documentid, c_userid AS userid, c_contenttype AS contenttype, c_type AS type,
         c_level AS level, c_sequence AS sequence, c_name AS name, c_body AS body, c_revisions AS revisions, c_templateid AS templateid,
         c_status AS status, c_relativeid AS relativeid, c_created AS created, c_revised AS revised
-        FROM dmz_section`+w)
+        FROM dmz_section`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.section")
 	}
 
+	if b.Spec.AnonymizeContent {
+		scrubPages(sec)
+	}
+
 	content, err := toJSON(sec)
 	if err != nil {
 		return errors.Wrap(err, "json.section")
@@ -608,16 +1026,20 @@ func (b backerHandler) dmzSection(files *[]backupItem) (err error) {
 
 	// Section Meta
 	sm := []page.Meta{}
-	err = b.Runtime.Db.Select(&sm, `
+	err = b.query("dmz_section_meta", &sm, `
         SELECT id, c_sectionid AS sectionid,
         c_orgid AS orgid, c_userid AS userid, c_docid AS documentid,
         c_rawbody AS rawbody, coalesce(c_config,`+b.Runtime.StoreProvider.JSONEmpty()+`) as config,
         c_external AS externalsource, c_created AS created, c_revised AS revised
-        FROM dmz_section_meta`+w)
+        FROM dmz_section_meta`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.sectionmeta")
 	}
 
+	if b.Spec.AnonymizeContent {
+		scrubPageMeta(sm)
+	}
+
 	content, err = toJSON(sm)
 	if err != nil {
 		return errors.Wrap(err, "json.sectionmeta")
@@ -626,7 +1048,7 @@ func (b backerHandler) dmzSection(files *[]backupItem) (err error) {
 
 	// Section Revision
 	sr := []page.Revision{}
-	err = b.Runtime.Db.Select(&sr, `
+	err = b.query("dmz_section_revision", &sr, `
         SELECT id, c_refid AS refid,
         c_orgid AS orgid, c_docid AS documentid, c_ownerid AS  ownerid,
         c_sectionid AS sectionid,
@@ -634,11 +1056,15 @@ func (b backerHandler) dmzSection(files *[]backupItem) (err error) {
         c_name AS name, c_body AS body, coalesce(c_rawbody, '') as rawbody,
         coalesce(c_config,`+b.Runtime.StoreProvider.JSONEmpty()+`) as config,
         c_created AS created, c_revised AS revised
-        FROM dmz_section_revision`+w)
+        FROM dmz_section_revision`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.sectionrevision")
 	}
 
+	if b.Spec.AnonymizeContent {
+		scrubRevisions(sr)
+	}
+
 	content, err = toJSON(sr)
 	if err != nil {
 		return errors.Wrap(err, "json.sectionrevision")
@@ -647,14 +1073,14 @@ func (b backerHandler) dmzSection(files *[]backupItem) (err error) {
 
 	// Section Template
 	st := []block.Block{}
-	err = b.Runtime.Db.Select(&st, `
+	err = b.query("dmz_section_template", &st, `
         SELECT id, c_refid as refid,
         c_orgid as orgid,
         c_spaceid AS spaceid, c_userid AS userid, c_contenttype AS contenttype, c_type AS type,
         c_name AS name, c_body AS body, c_desc AS excerpt, c_rawbody AS rawbody,
         c_config AS config, c_external AS externalsource, c_used AS used,
         c_created AS created, c_revised AS revised
-        FROM dmz_section_template`+w)
+        FROM dmz_section_template`+b.spaceScope(w, "c_spaceid"))
 	if err != nil {
 		return errors.Wrap(err, "select.sectiontemplate")
 	}
@@ -677,13 +1103,13 @@ func (b backerHandler) dmzDocument(files *[]backupItem) (err error) {
 
 	// Document
 	d := []doc.Document{}
-	err = b.Runtime.Db.Select(&d, `
+	err = b.query("dmz_doc", &d, `
         SELECT id, c_refid AS refid, c_orgid AS orgid, c_spaceid AS spaceid, c_userid AS userid,
         c_job AS job, c_location AS location, c_name AS name, c_desc AS excerpt, c_slug AS slug,
         c_tags AS tags, c_template AS template, c_protection AS protection, c_approval AS approval,
         c_lifecycle AS lifecycle, c_versioned AS versioned, c_versionid AS versionid,
         c_versionorder AS versionorder, c_seq AS sequence, c_groupid AS groupid, c_created AS created, c_revised AS revised
-        FROM dmz_doc`+w)
+        FROM dmz_doc`+b.docScope(w, "c_refid"))
 	if err != nil {
 		return errors.Wrap(err, "select.document")
 	}
@@ -696,11 +1122,11 @@ func (b backerHandler) dmzDocument(files *[]backupItem) (err error) {
 
 	// Vote
 	vt := []vote{}
-	err = b.Runtime.Db.Select(&vt, `
+	err = b.query("dmz_doc_vote", &vt, `
         SELECT c_refid AS refid, c_orgid AS orgid,
         c_voter AS voterid, c_vote AS vote,
         c_docid AS documentid, c_created AS created, c_revised AS revised
-        FROM dmz_doc_vote`+w)
+        FROM dmz_doc_vote`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.docvote")
 	}
@@ -713,12 +1139,12 @@ func (b backerHandler) dmzDocument(files *[]backupItem) (err error) {
 
 	// Link
 	ln := []link.Link{}
-	err = b.Runtime.Db.Select(&ln, `
+	err = b.query("dmz_doc_link", &ln, `
         select c_refid AS refid, c_orgid AS orgid, c_spaceid AS spaceid, c_userid AS userid,
         c_sourcedocid AS sourcedocumentid, c_sourcesectionid AS sourcesectionid,
         c_targetdocid AS targetdocumentid, c_targetid AS targetid, c_externalid AS externalid,
         c_type as linktype, c_orphan As orphan, c_created AS created, c_revised AS revised
-        FROM dmz_doc_link`+w)
+        FROM dmz_doc_link`+b.docScope(w, "c_sourcedocid"))
 	if err != nil {
 		return errors.Wrap(err, "select.doclink")
 	}
@@ -731,16 +1157,23 @@ func (b backerHandler) dmzDocument(files *[]backupItem) (err error) {
 
 	// Comment
 	cm := []comment{}
-	err = b.Runtime.Db.Select(&cm, `
+	err = b.query("dmz_doc_comment", &cm, `
         SELECT c_refid AS refid, c_orgid AS orgid, c_docid AS documentid,
         c_userid AS userid, c_email AS email,
 		c_feedback AS feedback, c_sectionid AS sectionid, c_replyto AS replyto,
 		c_created AS created
-        FROM dmz_doc_comment`+w)
+        FROM dmz_doc_comment`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.doccomment")
 	}
 
+	if b.Spec.Anonymize {
+		anonymizeComments(cm)
+	}
+	if b.Spec.AnonymizeContent {
+		scrubComments(cm)
+	}
+
 	content, err = toJSON(cm)
 	if err != nil {
 		return errors.Wrap(err, "json.doccomment")
@@ -749,15 +1182,19 @@ func (b backerHandler) dmzDocument(files *[]backupItem) (err error) {
 
 	// Share
 	sh := []share{}
-	err = b.Runtime.Db.Select(&sh, `
+	err = b.query("dmz_doc_share", &sh, `
         SELECT id AS id, c_orgid AS orgid, c_docid AS documentid,
         c_userid AS userid, c_email AS email, c_message AS message, c_viewed AS viewed,
         c_expires AS expires, c_active AS active, c_secret AS secret, c_created AS created
-        FROM dmz_doc_share`+w)
+        FROM dmz_doc_share`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.docshare")
 	}
 
+	if b.Spec.Anonymize {
+		anonymizeShares(sh)
+	}
+
 	content, err = toJSON(sh)
 	if err != nil {
 		return errors.Wrap(err, "json.docshare")
@@ -766,16 +1203,20 @@ func (b backerHandler) dmzDocument(files *[]backupItem) (err error) {
 
 	// Attachment
 	at := []attachment.Attachment{}
-	err = b.Runtime.Db.Select(&at, `
+	err = b.query("dmz_doc_attachment", &at, `
         SELECT id, c_refid AS refid,
         c_orgid AS orgid, c_docid AS documentid, c_sectionid AS sectionid, c_job AS job, c_fileid AS fileid,
         c_filename AS filename, c_data AS data, c_extension AS extension,
         c_created AS created, c_revised AS revised
-        FROM dmz_doc_attachment`+w)
+        FROM dmz_doc_attachment`+b.docScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.docattachment")
 	}
 
+	if b.Spec.AnonymizeContent {
+		scrubAttachments(at)
+	}
+
 	content, err = toJSON(at)
 	if err != nil {
 		return errors.Wrap(err, "json.docattachment")
@@ -793,12 +1234,12 @@ func (b backerHandler) dmzAction(files *[]backupItem) (err error) {
 	}
 
 	ac := []action.UserAction{}
-	err = b.Runtime.Db.Select(&ac, `
+	err = b.query("dmz_action", &ac, `
         SELECT c_refid AS refid, c_orgid AS orgid, c_docid AS documentid, c_userid AS userid,
         c_actiontype AS actiontype, c_note AS note, c_requestorid AS requestorid, c_requested AS requested, c_due AS due,
         c_completed AS completed, c_iscomplete AS iscomplete, c_reftype AS reftype, c_reftypeid AS reftypeid,
         c_created AS created, c_revised AS revised
-        FROM dmz_action`+w)
+        FROM dmz_action`+b.spaceDocScope(w, "c_docid"))
 	if err != nil {
 		return errors.Wrap(err, "select.action")
 	}
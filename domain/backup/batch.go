@@ -0,0 +1,127 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Restore inserts are grouped into multi-row INSERT statements
+// to cut round trips on large restores. Batch size is bounded by
+// provider specific placeholder limits so a batch never exceeds
+// what the database driver accepts.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/documize/community/core/env"
+)
+
+// Placeholder limits per statement.
+const (
+	maxParamsSQLServer = 2000 // hard limit is 2100
+	maxRowsSQLServer   = 1000 // row value expressions per INSERT
+	maxParamsDefault   = 65000
+)
+
+// defaultBatchSize returns number of rows per INSERT when not configured.
+// MySQL stays conservative because large row bodies count against max_allowed_packet.
+func defaultBatchSize(t env.StoreType) int {
+	switch t {
+	case env.StoreTypePostgreSQL:
+		return 250
+	case env.StoreTypeSQLServer:
+		return maxRowsSQLServer
+	default:
+		return 100
+	}
+}
+
+// batchSize returns safe rows per INSERT for given column count.
+func batchSize(t env.StoreType, configured, columns int) int {
+	size := configured
+	if size <= 0 {
+		size = defaultBatchSize(t)
+	}
+
+	maxParams := maxParamsDefault
+	if t == env.StoreTypeSQLServer {
+		maxParams = maxParamsSQLServer
+		if size > maxRowsSQLServer {
+			size = maxRowsSQLServer
+		}
+	}
+	if columns > 0 && size*columns > maxParams {
+		size = maxParams / columns
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// batchSQL returns multi-row INSERT statement using ? placeholders.
+func batchSQL(table, columns string, rows int) string {
+	n := len(strings.Split(columns, ","))
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+
+	values := make([]string, rows)
+	for i := range values {
+		values[i] = row
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, columns, strings.Join(values, ", "))
+}
+
+// rowBatch buffers rows for a single table until batch is full.
+type rowBatch struct {
+	r       *restoreHandler
+	table   string
+	columns string
+	size    int
+	rows    int
+	args    []interface{}
+}
+
+// newBatch prepares batched inserts for table within current transaction.
+// Columns are given as comma separated list.
+func (r *restoreHandler) newBatch(table, columns string) *rowBatch {
+	n := len(strings.Split(columns, ","))
+	size := batchSize(r.Runtime.StoreProvider.Type(), r.Spec.BatchSize, n)
+
+	return &rowBatch{r: r, table: table, columns: columns, size: size,
+		args: make([]interface{}, 0, size*n)}
+}
+
+// add queues row values, writing batch once full.
+func (b *rowBatch) add(values ...interface{}) error {
+	b.args = append(b.args, values...)
+	b.rows++
+
+	if b.rows >= b.size {
+		return b.flush()
+	}
+
+	return nil
+}
+
+// flush writes any queued rows.
+func (b *rowBatch) flush() (err error) {
+	if b.rows == 0 {
+		return nil
+	}
+
+	_, err = b.r.Context.Transaction.Exec(b.r.Runtime.Db.Rebind(batchSQL(b.table, b.columns, b.rows)), b.args...)
+
+	b.rows = 0
+	b.args = b.args[:0]
+
+	return
+}
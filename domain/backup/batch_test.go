@@ -0,0 +1,50 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"testing"
+
+	"github.com/documize/community/core/env"
+)
+
+func TestBatchSize(t *testing.T) {
+	if n := batchSize(env.StoreTypeMySQL, 0, 10); n != 100 {
+		t.Errorf("expected 100 got %d", n)
+	}
+	if n := batchSize(env.StoreTypePostgreSQL, 0, 10); n != 250 {
+		t.Errorf("expected 250 got %d", n)
+	}
+	if n := batchSize(env.StoreTypeMySQL, 500, 10); n != 500 {
+		t.Errorf("expected 500 got %d", n)
+	}
+
+	// SQL Server is capped by parameter count.
+	if n := batchSize(env.StoreTypeSQLServer, 0, 16); n != 125 {
+		t.Errorf("expected 125 got %d", n)
+	}
+	if n := batchSize(env.StoreTypeSQLServer, 0, 1); n != 1000 {
+		t.Errorf("expected 1000 got %d", n)
+	}
+
+	// Never below one row.
+	if n := batchSize(env.StoreTypeSQLServer, 0, 5000); n != 1 {
+		t.Errorf("expected 1 got %d", n)
+	}
+}
+
+func TestBatchSQL(t *testing.T) {
+	q := batchSQL("dmz_pin", "c_refid, c_name", 3)
+	if q != "INSERT INTO dmz_pin (c_refid, c_name) VALUES (?, ?), (?, ?), (?, ?)" {
+		t.Errorf("unexpected SQL %s", q)
+	}
+}
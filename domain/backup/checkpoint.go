@@ -0,0 +1,117 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Config key holding restore progress.
+const checkpointKey = "RESTORE_CHECKPOINT"
+
+// Records last table successfully restored so that a failed
+// restore can be resumed without redoing completed work.
+// ID remapping is kept because later tables depend on it.
+type checkpoint struct {
+	Checksum  string            `json:"checksum"`
+	OrgID     string            `json:"org"`
+	Table     string            `json:"table"`
+	MapOrgID  map[string]string `json:"mapOrg"`
+	MapUserID map[string]string `json:"mapUser"`
+	MapID     map[string]string `json:"mapId"`
+}
+
+// Identifies backup file so checkpoint is only used with same archive.
+func archiveChecksum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Returns index of first step to run after last completed table.
+func resumeIndex(steps []restoreStep, table string) int {
+	for i := range steps {
+		if steps[i].table == table {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// Loads checkpoint for current archive and organization, if any.
+func (r *restoreHandler) loadCheckpoint() (cp checkpoint, found bool) {
+	var c string
+	err := r.Runtime.Db.Get(&c, r.Runtime.Db.Rebind("SELECT c_config FROM dmz_config WHERE c_key=?"), checkpointKey)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		r.Runtime.Log.Error("restore.loadCheckpoint", err)
+		return
+	}
+
+	err = json.Unmarshal([]byte(c), &cp)
+	if err != nil {
+		r.Runtime.Log.Error("restore.loadCheckpoint", err)
+		return
+	}
+
+	if cp.Checksum != r.Checksum || cp.OrgID != r.Spec.Org.RefID || len(cp.Table) == 0 {
+		r.Runtime.Log.Info("Restore checkpoint belongs to different backup file, ignoring")
+		return
+	}
+
+	if cp.MapOrgID == nil {
+		cp.MapOrgID = make(map[string]string)
+	}
+	if cp.MapUserID == nil {
+		cp.MapUserID = make(map[string]string)
+	}
+	if cp.MapID == nil {
+		cp.MapID = make(map[string]string)
+	}
+
+	return cp, true
+}
+
+// Durably records table as restored.
+// Failure to save is logged, restore carries on.
+func (r *restoreHandler) saveCheckpoint(table string) {
+	cp := checkpoint{
+		Checksum:  r.Checksum,
+		OrgID:     r.Spec.Org.RefID,
+		Table:     table,
+		MapOrgID:  r.MapOrgID,
+		MapUserID: r.MapUserID,
+		MapID:     r.MapID,
+	}
+
+	j, err := json.Marshal(cp)
+	if err == nil {
+		err = r.Store.Setting.Set(checkpointKey, string(j))
+	}
+	if err != nil {
+		r.Runtime.Log.Error(fmt.Sprintf("restore.saveCheckpoint %s", table), err)
+	}
+}
+
+// Removes checkpoint once restore completes.
+func (r *restoreHandler) clearCheckpoint() {
+	_, err := r.Runtime.Db.Exec(r.Runtime.Db.Rebind("DELETE FROM dmz_config WHERE c_key=?"), checkpointKey)
+	if err != nil {
+		r.Runtime.Log.Error("restore.clearCheckpoint", err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Backup and restore can also be run from the command line,
+// e.g. for scheduled jobs and migrations, instead of over HTTP:
+//
+//   documize backup -dbtype=mysql -db=... -org=* -out=backup.zip
+//   documize restore -dbtype=mysql -db=... -org=<orgID> -in=backup.zip
+//
+// Archives are written to stdout or read from stdin when -out or -in
+// is "-" (the default), so logging goes to stderr. Commands run with
+// system privileges against existing installations; empty databases
+// are restored at startup using -restorefile instead.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain"
+	indexer "github.com/documize/community/domain/search"
+	"github.com/documize/community/domain/store"
+	m "github.com/documize/community/model/backup"
+)
+
+// Command holds backup or restore command line options.
+type Command struct {
+	Name   string
+	Path   string // archive file, "-" for stdin/stdout
+	Export m.ExportSpec
+	Import m.ImportSpec
+
+	// Restore only.
+	OrgID   string // organization restored into
+	DryRun  bool
+	Reindex bool
+
+	// Raw values converted once flags are parsed.
+	exclude, labels, dateFrom, dateTo string
+}
+
+// ParseCommand returns command named by first program argument
+// and removes it from os.Args so that remaining flags parse as usual.
+// Command flags are registered with flag.CommandLine and take values
+// once env.LoadConfig parses command line.
+// Returns nil if program is not run as backup or restore command.
+func ParseCommand() *Command {
+	if len(os.Args) < 2 {
+		return nil
+	}
+
+	c := &Command{Name: os.Args[1]}
+	if c.Name != "backup" && c.Name != "restore" {
+		return nil
+	}
+
+	os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	c.register(flag.CommandLine)
+
+	return c
+}
+
+// register adds command flags to flag set.
+func (c *Command) register(fs *flag.FlagSet) {
+	// Passphrase is best kept out of process list.
+	passphrase := os.Getenv("DOCUMIZEPASSPHRASE")
+	passphraseUsage := "passphrase for encrypted backup, defaults to DOCUMIZEPASSPHRASE environment variable"
+	passphraseRefUsage := "fetch passphrase from credential provider, e.g. file:/run/secrets/backup_key or env:BACKUP_KEY"
+
+	switch c.Name {
+	case "backup":
+		fs.StringVar(&c.Export.OrgID, "org", "", `organization ID to back up, "*" for system backup`)
+		fs.StringVar(&c.Path, "out", "-", `file backup is written to, "-" for stdout`)
+		fs.StringVar(&c.Export.SpaceID, "space", "", "space ID to limit backup to")
+		fs.StringVar(&c.exclude, "exclude", "", "comma separated database tables to leave out")
+		fs.StringVar(&c.labels, "labels", "", "comma separated key=value labels recorded in manifest")
+		fs.StringVar(&c.dateFrom, "datefrom", "", "only documents created or revised from this date (YYYY-MM-DD or RFC3339)")
+		fs.StringVar(&c.dateTo, "dateto", "", "only documents created or revised up to this date (YYYY-MM-DD or RFC3339)")
+		fs.BoolVar(&c.Export.Verify, "verify", false, "re-read archive and check row counts")
+		fs.BoolVar(&c.Export.Anonymize, "anonymize", false, "replace personal data with pseudonyms")
+		fs.BoolVar(&c.Export.AnonymizeContent, "anonymizecontent", false, "also scrub document content")
+		fs.BoolVar(&c.Export.IncludeAudit, "includeaudit", false, "include audit log")
+		fs.Int64Var(&c.Export.MaxArchiveBytes, "maxarchivebytes", 0, "abort once archive grows beyond this size")
+		fs.Int64Var(&c.Export.SplitSizeBytes, "splitsizebytes", 0, "split archive into parts of this size, kept in backup temp folder")
+		fs.StringVar(&c.Export.Passphrase, "passphrase", passphrase, passphraseUsage)
+		fs.StringVar(&c.Export.PassphraseRef, "passphraseref", "", passphraseRefUsage)
+	case "restore":
+		fs.StringVar(&c.OrgID, "org", "", "organization ID restored into")
+		fs.StringVar(&c.Path, "in", "-", `backup file to restore, "-" for stdin`)
+		fs.BoolVar(&c.Import.OverwriteOrg, "overwriteorg", false, "overwrite organization settings")
+		fs.BoolVar(&c.Import.Merge, "merge", false, "only apply rows newer than existing ones")
+		fs.BoolVar(&c.Import.MatchEmail, "matchemail", false, "match users by email")
+		fs.BoolVar(&c.Import.Resume, "resume", false, "resume from last table restored by failed attempt")
+		fs.BoolVar(&c.Import.RenameConflicts, "renameconflicts", false, "rename spaces and categories with clashing names")
+		fs.BoolVar(&c.Import.IncludeAudit, "includeaudit", false, "restore audit log held in backup")
+		fs.IntVar(&c.Import.BatchSize, "batchsize", 0, "rows per restore INSERT statement")
+		fs.StringVar(&c.Import.IDMode, "ids", "", "preserve or regenerate record IDs")
+		fs.StringVar(&c.Import.Passphrase, "passphrase", passphrase, passphraseUsage)
+		fs.StringVar(&c.Import.PassphraseRef, "passphraseref", "", passphraseRefUsage)
+		fs.BoolVar(&c.DryRun, "dryrun", false, "verify archive and print its manifest without restoring")
+		fs.BoolVar(&c.Reindex, "reindex", true, "rebuild search index after restore")
+	}
+}
+
+// finish converts raw flag values into specs.
+func (c *Command) finish() (err error) {
+	for _, t := range strings.Split(c.exclude, ",") {
+		if t = strings.TrimSpace(t); len(t) > 0 {
+			c.Export.ExcludeTables = append(c.Export.ExcludeTables, t)
+		}
+	}
+
+	for _, kv := range strings.Split(c.labels, ",") {
+		if kv = strings.TrimSpace(kv); len(kv) == 0 {
+			continue
+		}
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 || len(p[0]) == 0 {
+			return fmt.Errorf("label %q must be key=value", kv)
+		}
+		if c.Export.Labels == nil {
+			c.Export.Labels = make(map[string]string)
+		}
+		c.Export.Labels[p[0]] = p[1]
+	}
+
+	if c.Export.DateFrom, err = parseCommandDate(c.dateFrom); err != nil {
+		return errors.Wrap(err, "datefrom")
+	}
+	if c.Export.DateTo, err = parseCommandDate(c.dateTo); err != nil {
+		return errors.Wrap(err, "dateto")
+	}
+
+	switch c.Name {
+	case "backup":
+		if len(c.Export.OrgID) == 0 {
+			return errors.New(`-org required, use "*" for system backup`)
+		}
+	case "restore":
+		if len(c.OrgID) == 0 {
+			return errors.New("-org required")
+		}
+	}
+
+	return nil
+}
+
+// parseCommandDate accepts plain dates as well as RFC3339 timestamps.
+func parseCommandDate(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// Run performs command against runtime prepared by boot.InitRuntime.
+func (c *Command) Run(rt *env.Runtime, s *store.Store, stdin io.Reader, stdout io.Writer) error {
+	if err := c.finish(); err != nil {
+		return err
+	}
+	if rt.Flags.SiteMode != env.SiteModeNormal {
+		return errors.New("database is not ready for backup or restore, complete setup first or use -restorefile for empty database")
+	}
+
+	ctx := domain.RequestContext{Administrator: true, GlobalAdmin: true}
+
+	if c.Name == "backup" {
+		ctx.OrgID = c.Export.OrgID
+		bh := backerHandler{Runtime: rt, Store: s, Context: ctx, Spec: c.Export, Cancel: rt.ShutdownDone()}
+		return c.backup(bh, stdout)
+	}
+
+	ctx.OrgID = c.OrgID
+	org, err := s.Organization.GetOrganization(ctx, c.OrgID)
+	if err != nil {
+		return errors.Wrap(err, "cannot load organization "+c.OrgID)
+	}
+	c.Import.Org = org
+
+	rh := restoreHandler{Runtime: rt, Store: s, Context: ctx, Spec: c.Import}
+	if err = c.restore(&rh, stdin, stdout); err != nil {
+		return err
+	}
+
+	if c.Reindex && !c.DryRun {
+		ix := indexer.NewIndexer(rt, s)
+		ix.RebuildBatched(ctx, indexer.DefaultRebuildOptions())
+	}
+
+	return nil
+}
+
+func (c *Command) backup(bh backerHandler, stdout io.Writer) error {
+	filename, err := bh.GenerateBackup()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filename)
+
+	// Split parts stay in backup temp folder, caller receives part manifest.
+	if bh.Spec.SplitSizeBytes > 0 {
+		bh.Runtime.Log.Info("Backup split into parts held in " + bh.Runtime.Flags.BackupTempDir)
+	}
+
+	n, err := copyOut(filename, c.Path, stdout)
+	if err != nil {
+		return err
+	}
+
+	bh.Runtime.Log.Info(fmt.Sprintf("Backup completed for %s, size %d", bh.Spec.OrgID, n))
+	return nil
+}
+
+func (c *Command) restore(rh *restoreHandler, stdin io.Reader, stdout io.Writer) error {
+	b, err := readIn(c.Path, stdin)
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		if err = rh.Validate(b, int64(len(b))); err != nil {
+			return err
+		}
+		j, err := toJSON(rh.Spec.Manifest)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, j)
+		return err
+	}
+
+	if err = rh.PerformRestore(b, int64(len(b))); err != nil {
+		return err
+	}
+
+	for _, d := range rh.Discrepancies {
+		rh.Runtime.Log.Info("Restore discrepancy: " + d)
+	}
+	rh.Runtime.Log.Info("Restore completed")
+
+	return nil
+}
+
+// copyOut writes file to path, or stdout if path is "-".
+func copyOut(filename, path string, stdout io.Writer) (n int64, err error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	w := stdout
+	if path != "-" && len(path) > 0 {
+		out, e := os.Create(path)
+		if e != nil {
+			return 0, e
+		}
+		defer func() {
+			if e := out.Close(); err == nil {
+				err = e
+			}
+		}()
+		w = out
+	}
+
+	return io.Copy(w, in)
+}
+
+// readIn reads file at path, or stdin if path is "-".
+func readIn(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" || len(path) == 0 {
+		return ioutil.ReadAll(stdin)
+	}
+
+	return ioutil.ReadFile(path)
+}
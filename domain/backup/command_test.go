@@ -0,0 +1,151 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+	m "github.com/documize/community/model/backup"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(message string)                    {}
+func (nopLogger) Infof(message string, a ...interface{}) {}
+func (nopLogger) Trace(message string)                   {}
+func (nopLogger) Error(message string, err error)        {}
+
+func parseTestCommand(t *testing.T, name string, args ...string) (*Command, error) {
+	c := &Command{Name: name}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, c.finish()
+}
+
+func TestBackupCommandFlags(t *testing.T) {
+	c, err := parseTestCommand(t, "backup", "-org=o1", "-out=x.zip", "-exclude=dmz_doc, dmz_pin",
+		"-labels=env=prod,ticket=42", "-datefrom=2026-01-01", "-dateto=2026-02-01T10:00:00Z", "-verify", "-anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := c.Export
+	if e.OrgID != "o1" || c.Path != "x.zip" || !e.Verify || !e.Anonymize || e.IncludeAudit {
+		t.Errorf("unexpected spec %+v", e)
+	}
+	if len(e.ExcludeTables) != 2 || e.ExcludeTables[0] != "dmz_doc" || e.ExcludeTables[1] != "dmz_pin" {
+		t.Errorf("unexpected excluded tables %v", e.ExcludeTables)
+	}
+	if e.Labels["env"] != "prod" || e.Labels["ticket"] != "42" {
+		t.Errorf("unexpected labels %v", e.Labels)
+	}
+	if !e.DateFrom.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) || !e.DateTo.Equal(time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected dates %s %s", e.DateFrom, e.DateTo)
+	}
+
+	if _, err := parseTestCommand(t, "backup"); err == nil {
+		t.Error("expected missing org error")
+	}
+	if _, err := parseTestCommand(t, "backup", "-org=*", "-labels=bad"); err == nil {
+		t.Error("expected bad label error")
+	}
+	if _, err := parseTestCommand(t, "backup", "-org=*", "-datefrom=yesterday"); err == nil {
+		t.Error("expected bad date error")
+	}
+}
+
+func TestRestoreCommandFlags(t *testing.T) {
+	c, err := parseTestCommand(t, "restore", "-org=o1", "-merge", "-ids=regenerate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OrgID != "o1" || c.Path != "-" || !c.Import.Merge || c.Import.IDMode != m.IDModeRegenerate || !c.Reindex {
+		t.Errorf("unexpected command %+v", c)
+	}
+
+	if _, err := parseTestCommand(t, "restore", "-in=x.zip"); err == nil {
+		t.Error("expected missing org error")
+	}
+}
+
+// Archive handed over by backup command, through either file or stdout,
+// is accepted by restore command dry run. Reading from and writing
+// to the database is not covered here.
+func TestCommandArchiveTransfer(t *testing.T) {
+	files := []backupItem{{Filename: "dmz_doc.json", Content: `[{"refId":"d1"}]`}}
+	mf, err := newManifest("b1", m.ExportSpec{OrgID: "o1"}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := toJSON(mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := writeTestZip(t, append(files, backupItem{Filename: "manifest.json", Content: c}))
+	defer os.Remove(archive)
+
+	dir, err := ioutil.TempDir("", "dmz-command-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Backup to file, restore from file.
+	path := filepath.Join(dir, "out.zip")
+	if _, err := copyOut(archive, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	rt := &env.Runtime{Log: nopLogger{}}
+	restore := &Command{Name: "restore", Path: path, DryRun: true}
+	var printed bytes.Buffer
+	if err := restore.restore(&restoreHandler{Runtime: rt}, nil, &printed); err != nil {
+		t.Fatal(err)
+	}
+	got := m.Manifest{}
+	if err := json.Unmarshal(printed.Bytes(), &got); err != nil || got.ID != "b1" {
+		t.Errorf("unexpected manifest %s %v", printed.String(), err)
+	}
+
+	// Backup to stdout, restore from stdin.
+	var stdout bytes.Buffer
+	n, err := copyOut(archive, "-", &stdout)
+	if err != nil || n != int64(stdout.Len()) {
+		t.Fatalf("unexpected stdout copy %d %v", n, err)
+	}
+	restore.Path = "-"
+	rh := &restoreHandler{Runtime: rt}
+	printed.Reset()
+	if err := restore.restore(rh, &stdout, &printed); err != nil {
+		t.Fatal(err)
+	}
+	if rh.Spec.Manifest.ID != "b1" || rh.Spec.Manifest.Counts["dmz_doc"] != 1 {
+		t.Errorf("unexpected manifest %+v", rh.Spec.Manifest)
+	}
+
+	// Damaged archive is rejected.
+	restore.Path = "-"
+	if err := restore.restore(&restoreHandler{Runtime: rt}, bytes.NewReader([]byte("not a zip")), &printed); err == nil {
+		t.Error("expected damaged archive to be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/documize/community/core/stringutil"
+	"github.com/documize/community/model/category"
+	"github.com/documize/community/model/space"
+	"github.com/pkg/errors"
+)
+
+// Spaces and categories are addressed by slug derived from their name.
+// Merge restores keep existing rows so incoming rows with a different
+// ID but same slug would leave users with two indistinguishable entries.
+// We detect such clashes before touching any data and either report
+// them or rename incoming rows if asked to.
+func (r *restoreHandler) checkSlugConflicts() (err error) {
+	if !r.Spec.Merge {
+		return nil
+	}
+
+	r.Renamed = make(map[string]string)
+	var conflicts []string
+
+	if !r.Spec.Manifest.IsExcluded("dmz_space") {
+		sp := []space.Space{}
+		err = r.fileJSON("dmz_space.json", &sp)
+		if err != nil {
+			return errors.Wrap(err, "failed to load dmz_space.json")
+		}
+
+		existing := []space.Space{}
+		err = r.Runtime.Db.Select(&existing, r.Runtime.Db.Rebind(`
+            SELECT c_refid AS refid, c_name AS name FROM dmz_space WHERE c_orgid=?`), r.Spec.Org.RefID)
+		if err != nil {
+			return errors.Wrap(err, "unable to load existing spaces")
+		}
+
+		taken := make(map[string]string)
+		for _, s := range existing {
+			taken[stringutil.MakeSlug(s.Name)] = s.RefID
+		}
+
+		for _, s := range sp {
+			c := r.resolveSlug("space", s.RefID, s.Name, "", taken)
+			if len(c) > 0 {
+				conflicts = append(conflicts, c)
+			}
+		}
+	}
+
+	if !r.Spec.Manifest.IsExcluded("dmz_category") {
+		ct := []category.Category{}
+		err = r.fileJSON("dmz_category.json", &ct)
+		if err != nil {
+			return errors.Wrap(err, "failed to load dmz_category.json")
+		}
+
+		existing := []category.Category{}
+		err = r.Runtime.Db.Select(&existing, r.Runtime.Db.Rebind(`
+            SELECT c_refid AS refid, c_spaceid AS spaceid, c_name AS name FROM dmz_category WHERE c_orgid=?`), r.Spec.Org.RefID)
+		if err != nil {
+			return errors.Wrap(err, "unable to load existing categories")
+		}
+
+		// Category names only need to be unique within their space.
+		taken := make(map[string]string)
+		for _, c := range existing {
+			taken[c.SpaceID+"/"+stringutil.MakeSlug(c.Name)] = c.RefID
+		}
+
+		for _, c := range ct {
+			x := r.resolveSlug("category", c.RefID, c.Name, c.SpaceID+"/", taken)
+			if len(x) > 0 {
+				conflicts = append(conflicts, x)
+			}
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	for _, c := range conflicts {
+		r.Runtime.Log.Info("Restore slug conflict: " + c)
+	}
+
+	if !r.Spec.RenameConflicts {
+		return fmt.Errorf("restore found %d slug conflicts, use renameConflicts option to rename incoming rows: %s",
+			len(conflicts), strings.Join(conflicts, "; "))
+	}
+
+	return nil
+}
+
+// Checks incoming row against taken slugs, returning description of any conflict.
+// With RenameConflicts set a free name is chosen and recorded.
+func (r *restoreHandler) resolveSlug(kind, refID, name, scope string, taken map[string]string) string {
+	key := scope + stringutil.MakeSlug(name)
+
+	owner, clash := taken[key]
+	if !clash || owner == refID {
+		taken[key] = refID
+		return ""
+	}
+
+	desc := fmt.Sprintf("%s %q (%s) clashes with existing %s", kind, name, refID, owner)
+
+	if r.Spec.RenameConflicts {
+		n := uniqueName(name, scope, taken)
+		r.Renamed[refID] = n
+		taken[scope+stringutil.MakeSlug(n)] = refID
+		desc += fmt.Sprintf(", renamed to %q", n)
+	}
+
+	return desc
+}
+
+// Returns name with numeric suffix whose slug is not yet taken.
+func uniqueName(name, scope string, taken map[string]string) string {
+	for i := 2; ; i++ {
+		n := fmt.Sprintf("%s (%d)", name, i)
+		if _, ok := taken[scope+stringutil.MakeSlug(n)]; !ok {
+			return n
+		}
+	}
+}
+
+// Returns renamed value for row if slug conflict was resolved.
+func (r *restoreHandler) rename(refID, name string) string {
+	if n, ok := r.Renamed[refID]; ok {
+		return n
+	}
+
+	return name
+}
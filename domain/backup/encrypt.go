@@ -0,0 +1,211 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Encrypted backups hold the zip archive sealed with AES-256-GCM
+// behind a small header:
+//
+//   magic "DMZENC" | version (1 byte) | iterations (4 bytes) | salt (16 bytes) | nonce (12 bytes)
+//
+// Key is derived from passphrase using PBKDF2-HMAC-SHA256.
+// Header is authenticated along with the archive.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/documize/community/core/secrets"
+)
+
+const (
+	encMagic      = "DMZENC"
+	encVersion    = 1
+	encIterations = 200000
+	encSaltSize   = 16
+	encNonceSize  = 12
+	encHeaderSize = len(encMagic) + 1 + 4 + encSaltSize + encNonceSize
+)
+
+// Reported when passphrase does not open encrypted backup.
+var errWrongPassphrase = errors.New("unable to decrypt backup, passphrase is wrong or file is damaged")
+
+// isEncrypted returns true if data starts with encrypted backup header.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encMagic))
+}
+
+// resolvePassphrase returns passphrase, fetching it from credential
+// provider when reference is given (see secrets.ResolveCredential).
+func resolvePassphrase(passphrase, ref string) (string, error) {
+	if len(ref) == 0 {
+		return passphrase, nil
+	}
+	if len(passphrase) > 0 {
+		return "", errors.New("passphrase and passphrase reference cannot both be set")
+	}
+
+	p, err := secrets.ResolveCredential(ref)
+	if err != nil {
+		return "", errors.New("unable to resolve backup passphrase: " + err.Error())
+	}
+	if len(p) == 0 {
+		return "", errors.New("backup passphrase reference resolved to empty value")
+	}
+
+	return p, nil
+}
+
+// openArchive returns archive ready for reading, decrypting it
+// with given or referenced passphrase when encrypted.
+func openArchive(data []byte, passphrase, ref string) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	p, err := resolvePassphrase(passphrase, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptArchive(data, p)
+}
+
+// encryptArchive seals archive using key derived from passphrase.
+func encryptArchive(archive []byte, passphrase string) ([]byte, error) {
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	header[len(encMagic)] = encVersion
+	binary.BigEndian.PutUint32(header[len(encMagic)+1:], encIterations)
+
+	salt := header[len(encMagic)+5 : len(encMagic)+5+encSaltSize]
+	nonce := header[encHeaderSize-encNonceSize:]
+	if _, err := io.ReadFull(rand.Reader, header[len(encMagic)+5:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, salt, encIterations)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(header, nonce, archive, header), nil
+}
+
+// decryptArchive opens archive sealed by encryptArchive.
+func decryptArchive(data []byte, passphrase string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("backup is encrypted, passphrase required")
+	}
+	if len(data) < encHeaderSize || !isEncrypted(data) {
+		return nil, errors.New("backup is not encrypted")
+	}
+	if data[len(encMagic)] != encVersion {
+		return nil, errors.New("unsupported encrypted backup version")
+	}
+
+	header := data[:encHeaderSize]
+	iterations := int(binary.BigEndian.Uint32(header[len(encMagic)+1:]))
+	if iterations < 1 || iterations > 100*encIterations {
+		return nil, errors.New("invalid encrypted backup header")
+	}
+	salt := header[len(encMagic)+5 : len(encMagic)+5+encSaltSize]
+	nonce := header[encHeaderSize-encNonceSize:]
+
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := aead.Open(nil, nonce, data[encHeaderSize:], header)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+
+	return archive, nil
+}
+
+// encryptFile replaces backup file with encrypted copy,
+// returning new filename.
+func encryptFile(filename, passphrase string) (string, error) {
+	archive, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := encryptArchive(archive, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	encrypted := filename + ".enc"
+	err = ioutil.WriteFile(encrypted, sealed, 0600)
+	if err != nil {
+		os.Remove(encrypted)
+		return "", err
+	}
+	os.Remove(filename)
+
+	return encrypted, nil
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2([]byte(passphrase), salt, iterations, 32, sha256.New)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives key as per RFC 8018.
+func pbkdf2(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	blocks := (keyLen + size - 1) / size
+
+	var key []byte
+	buf := make([]byte, 4)
+	u := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, size)
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
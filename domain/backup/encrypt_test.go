@@ -0,0 +1,205 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain"
+	m "github.com/documize/community/model/backup"
+)
+
+// RFC 7914 section 11 PBKDF2-HMAC-SHA256 test vectors.
+func TestPBKDF2(t *testing.T) {
+	k := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64, sha256.New)
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if hex.EncodeToString(k) != want {
+		t.Errorf("unexpected key %x", k)
+	}
+
+	k = pbkdf2([]byte("Password"), []byte("NaCl"), 80000, 64, sha256.New)
+	want = "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"
+	if hex.EncodeToString(k) != want {
+		t.Errorf("unexpected key %x", k)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	filename := writeTestZip(t, []backupItem{{Filename: "manifest.json", Content: `{"id":"x"}`}})
+	plain, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := encryptFile(filename, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(encrypted) != ".enc" {
+		t.Errorf("unexpected filename %s", encrypted)
+	}
+
+	sealed, err := ioutil.ReadFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(sealed) || isEncrypted(plain) {
+		t.Error("encryption header not detected correctly")
+	}
+	if bytes.Contains(sealed, []byte("manifest.json")) {
+		t.Error("archive contents visible in encrypted file")
+	}
+
+	opened, err := decryptArchive(sealed, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Error("decrypted archive differs from original")
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	sealed, err := encryptArchive([]byte("archive"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decryptArchive(sealed, "battery staple"); err != errWrongPassphrase {
+		t.Errorf("expected wrong passphrase error got %v", err)
+	}
+	if _, err := decryptArchive(sealed, ""); err == nil {
+		t.Error("expected passphrase required error")
+	}
+
+	// Tampered header fails authentication.
+	sealed[len(encMagic)+6] ^= 0xff
+	if _, err := decryptArchive(sealed, "correct horse"); err == nil {
+		t.Error("expected tampered header to fail")
+	}
+
+	// Restore refuses encrypted backup without passphrase.
+	r := restoreHandler{}
+	if err := r.PerformRestore(sealed, int64(len(sealed))); err == nil {
+		t.Error("expected restore to fail without passphrase")
+	}
+}
+
+// Passphrase held by credential provider must open the backup it sealed.
+func TestResolvePassphrase(t *testing.T) {
+	t.Setenv("DMZ_TEST_BACKUP_KEY", "correct horse")
+	keyFile := filepath.Join(t.TempDir(), "backup_key")
+	if err := ioutil.WriteFile(keyFile, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"env:DMZ_TEST_BACKUP_KEY", "file:" + keyFile} {
+		p, err := resolvePassphrase("", ref)
+		if err != nil || p != "correct horse" {
+			t.Errorf("unexpected passphrase %q %v for %s", p, err, ref)
+		}
+	}
+
+	if p, err := resolvePassphrase("plain", ""); err != nil || p != "plain" {
+		t.Errorf("expected plain passphrase got %q %v", p, err)
+	}
+	if _, err := resolvePassphrase("plain", "env:DMZ_TEST_BACKUP_KEY"); err == nil {
+		t.Error("expected error when both are set")
+	}
+	if _, err := resolvePassphrase("", "env:DMZ_TEST_MISSING_KEY"); err == nil {
+		t.Error("expected unresolved reference error")
+	}
+
+	// Restore resolves the same key by reference.
+	archive := writeTestZip(t, []backupItem{{Filename: "manifest.json", Content: `{"id":"x"}`}})
+	plain, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encryptArchive(plain, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := restoreHandler{Runtime: &env.Runtime{Log: nopLogger{}}, Spec: m.ImportSpec{PassphraseRef: "file:" + keyFile}}
+	if err := r.Validate(sealed, int64(len(sealed))); err != nil {
+		t.Errorf("expected backup to open by reference got %v", err)
+	}
+	if r.Spec.Manifest.ID != "x" {
+		t.Errorf("unexpected manifest %+v", r.Spec.Manifest)
+	}
+}
+
+// Startup restore and backup inspection open encrypted backups too.
+func TestOpenEncryptedBackup(t *testing.T) {
+	t.Setenv("DMZ_TEST_BACKUP_KEY", "correct horse")
+	archive := writeTestZip(t, []backupItem{{Filename: "manifest.json", Content: `{"id":"x","orgId":"*"}`}})
+	plain, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encryptArchive(plain, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "backup.dmzenc")
+	if err := ioutil.WriteFile(filename, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := readRestoreFile(filename, "env:DMZ_TEST_BACKUP_KEY")
+	if err != nil || !bytes.Equal(b, plain) {
+		t.Errorf("expected restore file to be decrypted got %v", err)
+	}
+	if _, err := readRestoreFile(filename, ""); err == nil {
+		t.Error("expected error for encrypted restore file without passphrase reference")
+	}
+	if b, err := readRestoreFile(archive, ""); err != nil || !bytes.Equal(b, plain) {
+		t.Errorf("expected plain restore file as is got %v", err)
+	}
+
+	inspect := func(fields map[string]string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		fw, _ := mw.CreateFormFile("restore-file", "backup.dmzenc")
+		fw.Write(sealed)
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/global/restore/inspect", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = req.WithContext(context.WithValue(req.Context(), domain.DocumizeContextKey, domain.RequestContext{Administrator: true}))
+		w := httptest.NewRecorder()
+		h := &Handler{Runtime: &env.Runtime{Log: nopLogger{}}}
+		h.Inspect(w, req)
+		return w
+	}
+
+	if w := inspect(map[string]string{"passphrase": "correct horse"}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"x"`) {
+		t.Errorf("expected manifest of encrypted backup got %d %s", w.Code, w.Body.String())
+	}
+	if w := inspect(nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected encrypted backup without passphrase to be refused got %d", w.Code)
+	}
+}
@@ -24,14 +24,14 @@ package backup
 // 4. GDPR compliance (send copy of data and nuke whatever remains).
 // 5. Setting up sample Documize instance with pre-defined content.
 //
-// The initial implementation is restricted to tenant or global
-// backup/restore operations and can only be performed by a verified
-// Global Administrator.
+// Tenant and global backup/restore operations can only be performed
+// by a verified Global Administrator.
 //
-// In future the process should be able to support per space backup/restore
-// operations. This is subject to further review.
+// Space backups can also be taken by owners of that space.
+// They are restored by merging into an existing tenant.
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -39,7 +39,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/documize/community/core/request"
 	"github.com/documize/community/model/audit"
@@ -48,9 +50,11 @@ import (
 	"github.com/documize/community/core/response"
 	"github.com/documize/community/core/streamutil"
 	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/permission"
 	indexer "github.com/documize/community/domain/search"
 	"github.com/documize/community/domain/store"
 	m "github.com/documize/community/model/backup"
+	pm "github.com/documize/community/model/permission"
 )
 
 // Handler contains the runtime information such as logging and database.
@@ -67,12 +71,6 @@ func (h *Handler) Backup(w http.ResponseWriter, r *http.Request) {
 	method := "system.backup"
 	ctx := domain.GetRequestContext(r)
 
-	if !ctx.Administrator {
-		response.WriteForbiddenError(w)
-		h.Runtime.Log.Info(fmt.Sprintf("Non-admin attempted system backup operation (user ID: %s)", ctx.UserID))
-		return
-	}
-
 	defer streamutil.Close(r.Body)
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -89,9 +87,40 @@ func (h *Handler) Backup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	owner := func() bool { return permission.HasPermission(ctx, *h.Store, spec.SpaceID, pm.SpaceOwner) }
+	if !canBackup(ctx, spec, owner) {
+		response.WriteForbiddenError(w)
+		h.Runtime.Log.Info(fmt.Sprintf("Non-admin attempted system backup operation (user ID: %s)", ctx.UserID))
+		return
+	}
+
+	// Request context is cancelled on shutdown or when caller goes away.
+	bh := backerHandler{Runtime: h.Runtime, Store: h.Store, Context: ctx, Spec: spec, Cancel: r.Context().Done()}
+
+	// Optional progress reporting to listener opened via BackupProgress.
+	bh.Progress = progressFor(ctx.UserID, request.Query(r, "progress"))
+
+	// Optional dry run validates spec and returns size estimate.
+	if dryRun, _ := strconv.ParseBool(request.Query(r, "dryRun")); dryRun {
+		est, err := bh.Estimate()
+		if err != nil {
+			response.WriteBadRequestError(w, method, err.Error())
+			h.Runtime.Log.Error(method, err)
+			return
+		}
+
+		response.WriteJSON(w, est)
+		return
+	}
+
 	h.Runtime.Log.Infof("Backup started %s", ctx.OrgID)
 
-	bh := backerHandler{Runtime: h.Runtime, Store: h.Store, Context: ctx, Spec: spec}
+	// Backups that are kept, verified, encrypted, split or uploaded need a file on disk,
+	// everything else goes straight to the caller.
+	if !spec.Retain && !spec.Verify && len(spec.Passphrase) == 0 && len(spec.PassphraseRef) == 0 && spec.SplitSizeBytes == 0 && spec.Destination == nil {
+		h.streamBackup(w, ctx, bh)
+		return
+	}
 
 	// Produce zip file on disk.
 	filename, err := bh.GenerateBackup()
@@ -101,29 +130,65 @@ func (h *Handler) Backup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read backup file into memory.
-	// DEBT: write file directly to HTTP response stream?
-	// defer out.Close()
-	// io.Copy(out, resp.Body)
+	// Split backups stay on disk, caller receives part manifest.
+	if spec.SplitSizeBytes > 0 {
+		index, err := ioutil.ReadFile(filename)
+		if err != nil {
+			response.WriteServerError(w, method, err)
+			h.Runtime.Log.Error(method, err)
+			return
+		}
 
-	bk, err := ioutil.ReadFile(filename)
+		h.Runtime.Log.Info(fmt.Sprintf("Backup completed for %s by %s, split parts listed in %s", ctx.OrgID, ctx.UserID, filename))
+		h.Store.Audit.Record(ctx, audit.EventTypeDatabaseBackup)
+
+		response.WriteJSON(w, json.RawMessage(index))
+		return
+	}
+
+	// Read backup file into memory.
+	bk, err := loadBackup(filename, spec.Retain)
 	if err != nil {
 		response.WriteServerError(w, method, err)
 		h.Runtime.Log.Error(method, err)
 		return
 	}
 
+	// Uploaded backups are not downloaded, caller receives object URL.
+	if spec.Destination != nil {
+		url, err := uploadS3(*spec.Destination, filepath.Base(filename), bk)
+		if err != nil {
+			response.WriteServerError(w, method, err)
+			h.Runtime.Log.Error(method, err)
+			return
+		}
+
+		h.Runtime.Log.Info(fmt.Sprintf("Backup completed for %s by %s, size %d uploaded to %s", ctx.OrgID, ctx.UserID, len(bk), url))
+		h.Store.Audit.Record(ctx, audit.EventTypeDatabaseBackup)
+
+		w.Header().Set("x-documize-filename", headerSafe(url))
+		response.WriteJSON(w, m.Upload{URL: url, Size: int64(len(bk))})
+		return
+	}
+
 	h.Runtime.Log.Info(fmt.Sprintf("Backup size of org %s pending download %d", ctx.OrgID, len(bk)))
 
+	// Caller only needs to know name of file, not where we put it.
+	name := filepath.Base(filename)
+
 	// Standard HTTP headers.
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`" ; `+`filename*="`+filename+`"`)
+	contentType := "application/zip"
+	if isEncrypted(bk) {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(name))
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(bk)))
 
 	// Custom HTTP header helps API consumer to extract backup filename cleanly
 	// instead of parsing 'Content-Disposition' header.
 	// This HTTP header is CORS white-listed.
-	w.Header().Set("x-documize-filename", filename)
+	w.Header().Set("x-documize-filename", safeFilename(name))
 	w.WriteHeader(http.StatusOK)
 
 	// Write backup to response stream.
@@ -136,13 +201,124 @@ func (h *Handler) Backup(w http.ResponseWriter, r *http.Request) {
 
 	h.Runtime.Log.Info(fmt.Sprintf("Backup completed for %s by %s, size %d", ctx.OrgID, ctx.UserID, x))
 	h.Store.Audit.Record(ctx, audit.EventTypeDatabaseBackup)
+}
+
+// Allows tests to induce read failures.
+var readFile = ioutil.ReadFile
 
-	// Delete backup file if not requested to keep it.
-	if !spec.Retain {
-		os.Remove(filename)
+// loadBackup reads backup file into memory.
+// File is removed afterwards, even when read fails, unless retained.
+func loadBackup(filename string, retain bool) (bk []byte, err error) {
+	defer func() {
+		if !retain {
+			os.Remove(filename)
+		}
+	}()
+
+	return readFile(filename)
+}
+
+// streamBackup writes backup zip directly to response
+// without holding it in memory or on disk.
+func (h *Handler) streamBackup(w http.ResponseWriter, ctx domain.RequestContext, bh backerHandler) {
+	method := "system.backup"
+
+	err := validateSpec(bh.Spec)
+	if err != nil {
+		bh.Progress.finish(err)
+		response.WriteBadRequestError(w, method, err.Error())
+		h.Runtime.Log.Error(method, err)
+		return
+	}
+
+	id := backupID()
+	name := backupFilename(id)
+
+	// Size is not known up front so there is no Content-Length.
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+	w.Header().Set("x-documize-filename", safeFilename(name))
+
+	n, err := bh.StreamBackup(w, id)
+	if err != nil {
+		h.Runtime.Log.Error(method, err)
+		// Once archive bytes are sent all we can do is cut the download short.
+		if n == 0 {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("x-documize-filename")
+			response.WriteServerError(w, method, err)
+		}
+		return
+	}
+
+	h.Runtime.Log.Info(fmt.Sprintf("Backup completed for %s by %s, size %d", ctx.OrgID, ctx.UserID, n))
+	h.Store.Audit.Record(ctx, audit.EventTypeDatabaseBackup)
+}
+
+// BackupProgress streams backup progress as server-sent events.
+// Open it with ?id=xyz before starting backup with ?progress=xyz.
+// Stream ends once backup completes or fails.
+func (h *Handler) BackupProgress(w http.ResponseWriter, r *http.Request) {
+	method := "system.backup.progress"
+	ctx := domain.GetRequestContext(r)
+
+	id := request.Query(r, "id")
+	if len(id) == 0 {
+		response.WriteMissingDataError(w, method, "id")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.WriteBadRequestError(w, method, "streaming not supported")
+		return
+	}
+
+	feed := watchProgress(ctx.UserID, id)
+	defer feed.unwatch(ctx.UserID, id)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-feed.events:
+			j, err := json.Marshal(e)
+			if err != nil {
+				h.Runtime.Log.Error(method, err)
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", j)
+			flusher.Flush()
+
+			if e.Complete {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
 	}
 }
 
+// canBackup decides if caller can take backup of given scope.
+// Administrators can back up anything, space owners only download their own space.
+func canBackup(ctx domain.RequestContext, spec m.ExportSpec, owner func() bool) bool {
+	if ctx.Administrator {
+		return true
+	}
+	if !spec.SpaceBackup() || spec.SystemBackup() || spec.OrgID != ctx.OrgID {
+		return false
+	}
+	// Keeping, verifying, splitting, uploading and server held keys work on the server side.
+	if spec.Retain || spec.Verify || spec.SplitSizeBytes != 0 || spec.Destination != nil || len(spec.PassphraseRef) > 0 {
+		return false
+	}
+
+	return owner()
+}
+
 // Restore receives ZIP file for restore operation.
 // Options are specified as HTTP query paramaters.
 func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
@@ -164,6 +340,31 @@ func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Optional merge mode only applies newer rows from backup.
+	merge, _ := strconv.ParseBool(request.Query(r, "merge"))
+
+	// Optional matching of users by email for system restores.
+	matchEmail, _ := strconv.ParseBool(request.Query(r, "matchEmail"))
+
+	// Optional resume from last table restored by failed attempt.
+	resume, _ := strconv.ParseBool(request.Query(r, "resume"))
+
+	// Optional renaming of spaces and categories with clashing slugs.
+	renameConflicts, _ := strconv.ParseBool(request.Query(r, "renameConflicts"))
+
+	// Optional restore of audit log held in backup file.
+	includeAudit, _ := strconv.ParseBool(request.Query(r, "audit"))
+
+	// Optional rows per restore INSERT statement.
+	batchSize, _ := strconv.Atoi(request.Query(r, "batchSize"))
+
+	// Optional ID handling: preserve (default) or regenerate.
+	idMode := request.Query(r, "ids")
+
+	// Encrypted backups need passphrase or reference to one, sent as form fields to keep them out of URL logs.
+	passphrase := r.FormValue("passphrase")
+	passphraseRef := r.FormValue("passphraseRef")
+
 	filedata, fileheader, err := r.FormFile("restore-file")
 	if err != nil {
 		response.WriteMissingDataError(w, method, "restore-file")
@@ -172,11 +373,25 @@ func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
 	}
 
 	b := new(bytes.Buffer)
-	_, err = io.Copy(b, filedata)
-	if err != nil {
-		h.Runtime.Log.Error(method, err)
-		response.WriteServerError(w, method, err)
-		return
+	size := r.ContentLength
+
+	// Split backups are uploaded as parts plus part manifest.
+	if _, _, e := r.FormFile("restore-parts"); e == nil {
+		joined, err := joinUploadedParts(r)
+		if err != nil {
+			response.WriteBadRequestError(w, method, err.Error())
+			h.Runtime.Log.Error(method, err)
+			return
+		}
+		b.Write(joined)
+		size = int64(len(joined))
+	} else {
+		_, err = io.Copy(b, filedata)
+		if err != nil {
+			h.Runtime.Log.Error(method, err)
+			response.WriteServerError(w, method, err)
+			return
+		}
 	}
 
 	h.Runtime.Log.Info(fmt.Sprintf("Restore file: %s %d", fileheader.Filename, len(b.Bytes())))
@@ -190,11 +405,24 @@ func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Prepare context and start restore process.
-	spec := m.ImportSpec{OverwriteOrg: overwriteOrg, Org: org}
+	spec := m.ImportSpec{OverwriteOrg: overwriteOrg, Merge: merge, MatchEmail: matchEmail, Resume: resume, RenameConflicts: renameConflicts, IncludeAudit: includeAudit, BatchSize: batchSize, IDMode: idMode, Passphrase: passphrase, PassphraseRef: passphraseRef, Org: org}
 	rh := restoreHandler{Runtime: h.Runtime, Store: h.Store, Context: ctx, Spec: spec}
 
+	// Optional dry run verifies archive and returns its manifest.
+	if dryRun, _ := strconv.ParseBool(request.Query(r, "dryRun")); dryRun {
+		err = rh.Validate(b.Bytes(), size)
+		if err != nil {
+			response.WriteBadRequestError(w, method, err.Error())
+			h.Runtime.Log.Error(method, err)
+			return
+		}
+
+		response.WriteJSON(w, rh.Spec.Manifest)
+		return
+	}
+
 	// Run the restore process.
-	err = rh.PerformRestore(b.Bytes(), r.ContentLength)
+	err = rh.PerformRestore(b.Bytes(), size)
 	if err != nil {
 		response.WriteServerError(w, method, err)
 		h.Runtime.Log.Error(method, err)
@@ -203,10 +431,141 @@ func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
 
 	h.Runtime.Log.Infof("Restore remapped %d OrgID values", len(rh.MapOrgID))
 	h.Runtime.Log.Infof("Restore remapped %d UserID values", len(rh.MapUserID))
+	for table, n := range rh.DroppedRows {
+		h.Runtime.Log.Infof("Restore dropped %s %d rows referencing missing users or groups", table, n)
+	}
+	if spec.Merge {
+		for table, n := range rh.MergedRows {
+			h.Runtime.Log.Infof("Restore merged %s %d rows", table, n)
+		}
+		for table, n := range rh.SkippedRows {
+			h.Runtime.Log.Infof("Restore skipped %s %d rows", table, n)
+		}
+	}
 	h.Runtime.Log.Info("Restore completed")
 
-	h.Runtime.Log.Info("Building search index")
-	go h.Indexer.Rebuild(ctx)
+	// Optionally reindex just the documents touched by restore.
+	if request.Query(r, "reindex") == "restored" {
+		docs := make([]string, 0, len(rh.Documents))
+		for id := range rh.Documents {
+			docs = append(docs, id)
+		}
+		h.Runtime.Log.Info(fmt.Sprintf("Building search index for %d restored documents", len(docs)))
+		go h.Indexer.ReindexDocuments(ctx, docs, reindexOptions(r))
+	} else {
+		h.Runtime.Log.Info("Building search index")
+		go h.Indexer.RebuildBatched(ctx, reindexOptions(r))
+	}
+
+	// Let caller know if anything did not land as expected.
+	if len(rh.Discrepancies) > 0 {
+		response.WriteJSON(w, rh.Discrepancies)
+		return
+	}
 
 	response.WriteEmpty(w)
 }
+
+// Inspect receives ZIP file and returns backup manifest
+// without performing any restore operation.
+func (h *Handler) Inspect(w http.ResponseWriter, r *http.Request) {
+	method := "system.inspect"
+	ctx := domain.GetRequestContext(r)
+
+	if !ctx.Administrator {
+		response.WriteForbiddenError(w)
+		h.Runtime.Log.Info(fmt.Sprintf("Non-admin attempted backup inspect operation (user ID: %s)", ctx.UserID))
+		return
+	}
+
+	filedata, _, err := r.FormFile("restore-file")
+	if err != nil {
+		response.WriteMissingDataError(w, method, "restore-file")
+		h.Runtime.Log.Error(method, err)
+		return
+	}
+
+	b := new(bytes.Buffer)
+	_, err = io.Copy(b, filedata)
+	if err != nil {
+		h.Runtime.Log.Error(method, err)
+		response.WriteServerError(w, method, err)
+		return
+	}
+
+	// Encrypted backups need passphrase or reference to one, as per restore.
+	archive, err := openArchive(b.Bytes(), r.FormValue("passphrase"), r.FormValue("passphraseRef"))
+	if err != nil {
+		response.WriteBadRequestError(w, method, err.Error())
+		h.Runtime.Log.Error(method, err)
+		return
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		response.WriteBadRequestError(w, method, "cannot read zip file")
+		h.Runtime.Log.Error(method, err)
+		return
+	}
+
+	rh := restoreHandler{Runtime: h.Runtime, Context: ctx, Zip: z}
+	err = rh.manifest()
+	if err != nil {
+		response.WriteBadRequestError(w, method, err.Error())
+		h.Runtime.Log.Error(method, err)
+		return
+	}
+
+	response.WriteJSON(w, rh.Spec.Manifest)
+}
+
+// Reassembles split backup from uploaded restore-file parts
+// and restore-parts part manifest.
+func joinUploadedParts(r *http.Request) (b []byte, err error) {
+	index, _, err := r.FormFile("restore-parts")
+	if err != nil {
+		return
+	}
+	defer streamutil.Close(index)
+
+	pm := m.PartManifest{}
+	err = json.NewDecoder(index).Decode(&pm)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read part manifest: %s", err.Error())
+	}
+
+	parts := make(map[string][]byte)
+	for _, fh := range r.MultipartForm.File["restore-file"] {
+		f, e := fh.Open()
+		if e != nil {
+			return nil, e
+		}
+		data, e := ioutil.ReadAll(f)
+		f.Close()
+		if e != nil {
+			return nil, e
+		}
+		parts[filepath.Base(fh.Filename)] = data
+	}
+
+	return joinParts(pm, parts)
+}
+
+// Search index rebuild pace can be tuned using optional
+// query parameters: reindexBatch, reindexConcurrency, reindexPause (milliseconds).
+// Use reindex=restored to limit rebuild to documents written by restore.
+func reindexOptions(r *http.Request) indexer.RebuildOptions {
+	opts := indexer.DefaultRebuildOptions()
+
+	if n, err := strconv.Atoi(request.Query(r, "reindexBatch")); err == nil && n > 0 {
+		opts.BatchSize = n
+	}
+	if n, err := strconv.Atoi(request.Query(r, "reindexConcurrency")); err == nil && n > 0 {
+		opts.Concurrency = n
+	}
+	if n, err := strconv.Atoi(request.Query(r, "reindexPause")); err == nil && n >= 0 {
+		opts.Pause = time.Duration(n) * time.Millisecond
+	}
+
+	return opts
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/documize/community/core/osutil"
+	m "github.com/documize/community/model/backup"
+)
+
+// Rough uncompressed JSON bytes per row, used to estimate backup size.
+// Content tables carry document bodies and attachments carry file data.
+var approxRowBytes = map[string]int64{
+	"dmz_section":          4096,
+	"dmz_section_meta":     4096,
+	"dmz_section_revision": 4096,
+	"dmz_section_template": 4096,
+	"dmz_doc_attachment":   262144,
+}
+
+const approxDefaultRowBytes = 512
+
+// Smallest split size accepted, anything less floods disk with parts.
+const minSplitSizeBytes = 1024 * 1024
+
+// validateSpec rejects export specifications that cannot produce usable backup.
+func validateSpec(spec m.ExportSpec) error {
+	// Organization data is required to restore anything else.
+	if spec.IsExcluded("dmz_org") {
+		return errors.New("dmz_org cannot be excluded from backup")
+	}
+	if !spec.DateFrom.IsZero() && !spec.DateTo.IsZero() && spec.DateTo.Before(spec.DateFrom) {
+		return errors.New("dateTo cannot be before dateFrom")
+	}
+	if spec.MaxArchiveBytes < 0 || spec.SplitSizeBytes < 0 {
+		return errors.New("size limits cannot be negative")
+	}
+	if spec.SplitSizeBytes > 0 && spec.SplitSizeBytes < minSplitSizeBytes {
+		return fmt.Errorf("split size cannot be less than %d bytes", minSplitSizeBytes)
+	}
+	if spec.SpaceBackup() && spec.SystemBackup() {
+		return errors.New("space backup requires organization")
+	}
+	if err := checkScopeIDs(spec); err != nil {
+		return err
+	}
+	if spec.Destination != nil {
+		if spec.SplitSizeBytes > 0 {
+			return errors.New("split backups cannot be uploaded")
+		}
+		if err := validateDestination(*spec.Destination); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkScopeIDs rejects organization and space IDs that are not record IDs.
+// Both find their way into SQL so this must pass before any query is built.
+func checkScopeIDs(spec m.ExportSpec) error {
+	if !spec.SystemBackup() && len(spec.OrgID) > 0 && !validRefID(spec.OrgID) {
+		return errors.New("invalid organization ID")
+	}
+	if spec.SpaceBackup() && !validRefID(spec.SpaceID) {
+		return errors.New("invalid space ID")
+	}
+
+	return nil
+}
+
+// validRefID returns true if id only holds record ID characters.
+func validRefID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// estimate sizes backup from row counts and warns about
+// anything likely to make backup fail or surprise the caller.
+// Free space of -1 means unknown.
+func estimate(spec m.ExportSpec, counts map[string]int, free int64) (e m.Estimate) {
+	e.Counts = counts
+	e.Tables = len(counts)
+
+	for table, n := range counts {
+		e.Rows += n
+
+		size, ok := approxRowBytes[table]
+		if !ok {
+			size = approxDefaultRowBytes
+		}
+		e.ApproxBytes += int64(n) * size
+	}
+
+	if spec.MaxArchiveBytes > 0 && e.ApproxBytes > spec.MaxArchiveBytes {
+		e.Warnings = append(e.Warnings, fmt.Sprintf("estimated size %d bytes exceeds maximum archive size %d bytes", e.ApproxBytes, spec.MaxArchiveBytes))
+	}
+	if free >= 0 && e.ApproxBytes > free {
+		e.Warnings = append(e.Warnings, fmt.Sprintf("estimated size %d bytes exceeds free disk space %d bytes", e.ApproxBytes, free))
+	}
+	if spec.IncludeAudit && !spec.DateBounded() {
+		e.Warnings = append(e.Warnings, "audit log included without date range")
+	}
+
+	return
+}
+
+// Estimate validates spec and sizes backup without producing archive.
+func (b backerHandler) Estimate() (e m.Estimate, err error) {
+	err = validateSpec(b.Spec)
+	if err != nil {
+		return
+	}
+
+	counts, err := b.Store.Backup.CountRows(b.Context, b.Spec)
+	if err != nil {
+		return
+	}
+
+	free := int64(-1)
+	if n, e2 := osutil.DiskFree(b.Runtime.Flags.BackupTempDir); e2 == nil {
+		free = int64(n)
+	}
+
+	return estimate(b.Spec, counts, free), nil
+}
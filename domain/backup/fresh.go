@@ -0,0 +1,104 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/documize/community/core/database"
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/store"
+	m "github.com/documize/community/model/backup"
+	"github.com/pkg/errors"
+)
+
+// RestoreEmpty stands up a new instance from a system backup file.
+// Database must be empty: schema is created to match the version
+// recorded in the backup, data is restored and the schema
+// is then upgraded to the current version.
+func RestoreEmpty(rt *env.Runtime, s *store.Store, filename string) (err error) {
+	exists, err := database.SchemaExists(rt)
+	if err != nil {
+		return errors.Wrap(err, "unable to get database table list")
+	}
+	if exists {
+		return errors.New("restore into empty database requires database without tables")
+	}
+
+	b, err := readRestoreFile(filename, rt.Flags.RestorePassphraseRef)
+	if err != nil {
+		return
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return errors.Wrap(err, "cannot read zip file")
+	}
+
+	// No users exist yet so restore runs with system privileges.
+	ctx := domain.RequestContext{Administrator: true, GlobalAdmin: true}
+	r := restoreHandler{Runtime: rt, Store: s, Context: ctx, Zip: z, Spec: m.ImportSpec{OverwriteOrg: true}}
+
+	err = r.manifest()
+	if err != nil {
+		return
+	}
+	if r.Spec.Manifest.OrgID != "*" {
+		return errors.New("restore into empty database requires system backup")
+	}
+
+	err = database.BootstrapVersion(rt, schemaVersion(r.Spec.Manifest, rt.StoreProvider.Type()))
+	if err != nil {
+		return
+	}
+
+	err = r.PerformRestore(b, int64(len(b)))
+	if err != nil {
+		return
+	}
+	for _, d := range r.Discrepancies {
+		rt.Log.Info("Restore discrepancy: " + d)
+	}
+
+	// Bring restored schema up to date.
+	return database.InstallUpgrade(rt, true)
+}
+
+// Reads backup file, decrypting it when encrypted using
+// passphrase held by credential provider reference.
+func readRestoreFile(filename, passphraseRef string) (b []byte, err error) {
+	b, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to read %s", filename))
+	}
+
+	if isEncrypted(b) && len(passphraseRef) == 0 {
+		return nil, errors.New("restore file is encrypted, passphrase reference required")
+	}
+
+	return openArchive(b, "", passphraseRef)
+}
+
+// Returns schema version to create before restore.
+// Version numbers only carry across the same database provider,
+// otherwise latest schema is used.
+func schemaVersion(mf m.Manifest, t env.StoreType) int {
+	if mf.StoreType != t {
+		return 0
+	}
+
+	return mf.SchemaVersion
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// headerSafe removes control characters (CR, LF and friends)
+// so that value cannot end header or inject new ones.
+func headerSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// safeFilename makes filename safe for use in HTTP headers.
+// Quotes, backslashes and path separators are replaced.
+func safeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '"', '\\', '/':
+			return '_'
+		}
+		return r
+	}, headerSafe(name))
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 || name == "." || name == ".." {
+		return "backup.zip"
+	}
+
+	return name
+}
+
+// contentDisposition formats attachment header as per RFC 6266.
+// Quoted filename holds ASCII fallback for older clients,
+// filename* holds UTF-8 name percent-encoded as per RFC 5987.
+func contentDisposition(name string) string {
+	name = safeFilename(name)
+
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, name)
+
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encodeRFC5987(name))
+}
+
+// encodeRFC5987 percent-encodes all bytes except RFC 5987 attr-char.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
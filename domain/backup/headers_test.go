@@ -0,0 +1,65 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name, expected string
+	}{
+		{"dmz-backup-1.zip", `attachment; filename="dmz-backup-1.zip"; filename*=UTF-8''dmz-backup-1.zip`},
+		{"a\r\nSet-Cookie: x=1.zip", `attachment; filename="aSet-Cookie: x=1.zip"; filename*=UTF-8''aSet-Cookie%3A%20x%3D1.zip`},
+		{`a"; filename="evil.exe`, `attachment; filename="a_; filename=_evil.exe"; filename*=UTF-8''a_%3B%20filename%3D_evil.exe`},
+		{`..\..\etc/passwd`, `attachment; filename=".._.._etc_passwd"; filename*=UTF-8''.._.._etc_passwd`},
+		{"résumé.zip", `attachment; filename="r_sum_.zip"; filename*=UTF-8''r%C3%A9sum%C3%A9.zip`},
+		{"\x00\x7f", `attachment; filename="backup.zip"; filename*=UTF-8''backup.zip`},
+	}
+
+	for _, tc := range tests {
+		if h := contentDisposition(tc.name); h != tc.expected {
+			t.Errorf("%q: expected %s got %s", tc.name, tc.expected, h)
+		}
+	}
+}
+
+// Adversarial names must not add or split response headers.
+func TestFilenameHeaders(t *testing.T) {
+	name := "x.zip\r\nX-Injected: 1\n\"quoted\""
+
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+	w.Header().Set("x-documize-filename", safeFilename(name))
+	w.WriteHeader(200)
+
+	res := w.Result()
+	if res.Header.Get("X-Injected") != "" {
+		t.Error("header injected")
+	}
+	for _, k := range []string{"Content-Disposition", "x-documize-filename"} {
+		v := res.Header.Get(k)
+		if strings.ContainsAny(v, "\r\n") {
+			t.Errorf("%s holds line break %q", k, v)
+		}
+	}
+	if v := res.Header.Get("x-documize-filename"); v != "x.zipX-Injected: 1_quoted_" {
+		t.Errorf("unexpected filename header %q", v)
+	}
+
+	if v := headerSafe("https://s3/b/k.zip\r\n"); v != "https://s3/b/k.zip" {
+		t.Errorf("unexpected header value %q", v)
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Restore either keeps entity IDs found in the backup file (preserve)
+// or gives every restored entity a new ID (regenerate).
+// Regenerated IDs are minted up front for every row held in the backup
+// so that references are rewritten consistently, whichever table
+// happens to be restored first. References to anything not held in the
+// backup are left untouched. Organization and user IDs follow their
+// own remapping rules and are never regenerated here.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/documize/community/core/uniqueid"
+	"github.com/documize/community/model/action"
+	"github.com/documize/community/model/activity"
+	"github.com/documize/community/model/attachment"
+	m "github.com/documize/community/model/backup"
+	"github.com/documize/community/model/block"
+	"github.com/documize/community/model/category"
+	"github.com/documize/community/model/doc"
+	"github.com/documize/community/model/group"
+	"github.com/documize/community/model/label"
+	"github.com/documize/community/model/link"
+	"github.com/documize/community/model/page"
+	"github.com/documize/community/model/permission"
+	"github.com/documize/community/model/pin"
+	"github.com/documize/community/model/space"
+	"github.com/pkg/errors"
+)
+
+// remapID returns regenerated ID, if any.
+func (r *restoreHandler) remapID(id string) string {
+	if n, ok := r.MapID[id]; ok {
+		return n
+	}
+	return id
+}
+
+// mintIDs assigns new ID to every entity ID found in backup.
+func (r *restoreHandler) mintIDs(ids map[string][]string) {
+	for _, table := range ids {
+		for _, id := range table {
+			if _, ok := r.MapID[id]; !ok && len(id) > 0 {
+				r.MapID[id] = uniqueid.Generate()
+			}
+		}
+	}
+}
+
+// backupIDs returns entity IDs held in backup file keyed by table.
+func (r *restoreHandler) backupIDs() (ids map[string][]string, err error) {
+	ids = make(map[string][]string)
+
+	load := func(table string, v interface{}, refIDs func() []string) error {
+		if r.Spec.Manifest.IsExcluded(table) {
+			return nil
+		}
+		if e := r.fileJSON(table+".json", v); e != nil {
+			return errors.Wrap(e, fmt.Sprintf("failed to load %s", table))
+		}
+		ids[table] = refIDs()
+		return nil
+	}
+
+	labels := []label.Label{}
+	spaces := []space.Space{}
+	categories := []category.Category{}
+	members := []category.Member{}
+	groups := []group.Group{}
+	pins := []pin.Pin{}
+	sections := []page.Page{}
+	revisions := []page.Revision{}
+	templates := []block.Block{}
+	documents := []doc.Document{}
+	votes := []vote{}
+	links := []link.Link{}
+	attachments := []attachment.Attachment{}
+	comments := []comment{}
+	actions := []action.UserAction{}
+
+	err = firstError(
+		load("dmz_space_label", &labels, func() (s []string) {
+			for i := range labels {
+				s = append(s, labels[i].RefID)
+			}
+			return
+		}),
+		load("dmz_space", &spaces, func() (s []string) {
+			for i := range spaces {
+				s = append(s, spaces[i].RefID)
+			}
+			return
+		}),
+		load("dmz_category", &categories, func() (s []string) {
+			for i := range categories {
+				s = append(s, categories[i].RefID)
+			}
+			return
+		}),
+		load("dmz_category_member", &members, func() (s []string) {
+			for i := range members {
+				s = append(s, members[i].RefID)
+			}
+			return
+		}),
+		load("dmz_group", &groups, func() (s []string) {
+			for i := range groups {
+				s = append(s, groups[i].RefID)
+			}
+			return
+		}),
+		load("dmz_pin", &pins, func() (s []string) {
+			for i := range pins {
+				s = append(s, pins[i].RefID)
+			}
+			return
+		}),
+		load("dmz_section", &sections, func() (s []string) {
+			for i := range sections {
+				s = append(s, sections[i].RefID)
+			}
+			return
+		}),
+		load("dmz_section_revision", &revisions, func() (s []string) {
+			for i := range revisions {
+				s = append(s, revisions[i].RefID)
+			}
+			return
+		}),
+		load("dmz_section_template", &templates, func() (s []string) {
+			for i := range templates {
+				s = append(s, templates[i].RefID)
+			}
+			return
+		}),
+		load("dmz_doc", &documents, func() (s []string) {
+			for i := range documents {
+				s = append(s, documents[i].RefID)
+			}
+			return
+		}),
+		load("dmz_doc_vote", &votes, func() (s []string) {
+			for i := range votes {
+				s = append(s, votes[i].RefID)
+			}
+			return
+		}),
+		load("dmz_doc_link", &links, func() (s []string) {
+			for i := range links {
+				s = append(s, links[i].RefID)
+			}
+			return
+		}),
+		load("dmz_doc_attachment", &attachments, func() (s []string) {
+			for i := range attachments {
+				s = append(s, attachments[i].RefID)
+			}
+			return
+		}),
+		load("dmz_doc_comment", &comments, func() (s []string) {
+			for i := range comments {
+				s = append(s, comments[i].RefID)
+			}
+			return
+		}),
+		load("dmz_action", &actions, func() (s []string) {
+			for i := range actions {
+				s = append(s, actions[i].RefID)
+			}
+			return
+		}),
+	)
+
+	return
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareIDs validates ID mode and mints new IDs when regenerating.
+// Preserved IDs must not already belong to another tenant.
+func (r *restoreHandler) prepareIDs() (err error) {
+	r.MapID = make(map[string]string)
+
+	regenerate := r.Spec.IDMode == m.IDModeRegenerate
+	if !regenerate && len(r.Spec.IDMode) > 0 && r.Spec.IDMode != m.IDModePreserve {
+		return fmt.Errorf("unknown ID mode %s", r.Spec.IDMode)
+	}
+	if regenerate && r.Spec.Merge {
+		return errors.New("merge restore requires preserved IDs")
+	}
+	// Everything is replaced by system restore so there is nothing to collide with.
+	if !regenerate && r.Spec.GlobalBackup {
+		return nil
+	}
+
+	ids, err := r.backupIDs()
+	if err != nil {
+		return
+	}
+
+	if regenerate {
+		r.mintIDs(ids)
+		r.Runtime.Log.Info(fmt.Sprintf("Restore regenerated %d IDs", len(r.MapID)))
+		return nil
+	}
+
+	return r.checkIDCollisions(ids)
+}
+
+// Reports IDs from backup that are already used by another tenant.
+func (r *restoreHandler) checkIDCollisions(ids map[string][]string) (err error) {
+	var found []string
+
+	for table, refIDs := range ids {
+		for start := 0; start < len(refIDs); start += 500 {
+			end := start + 500
+			if end > len(refIDs) {
+				end = len(refIDs)
+			}
+			chunk := refIDs[start:end]
+
+			args := []interface{}{r.Spec.Org.RefID}
+			for _, id := range chunk {
+				args = append(args, id)
+			}
+
+			var n int
+			err = r.Runtime.Db.Get(&n, r.Runtime.Db.Rebind(fmt.Sprintf(
+				"SELECT COUNT(*) FROM %s WHERE c_orgid<>? AND c_refid IN (%s)",
+				table, strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ","))), args...)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("unable to check IDs in %s", table))
+			}
+			if n > 0 {
+				found = append(found, fmt.Sprintf("%s %d", table, n))
+			}
+		}
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("backup IDs already used by another organization (%s), restore with regenerated IDs instead",
+			strings.Join(found, ", "))
+	}
+
+	return nil
+}
+
+// The following rewrite entity and reference IDs of loaded rows.
+// They leave rows untouched when IDs are preserved.
+
+func (r *restoreHandler) rekeyLabels(l []label.Label) {
+	for i := range l {
+		l[i].RefID = r.remapID(l[i].RefID)
+	}
+}
+
+func (r *restoreHandler) rekeySpaces(sp []space.Space) {
+	for i := range sp {
+		sp[i].RefID = r.remapID(sp[i].RefID)
+		sp[i].LabelID = r.remapID(sp[i].LabelID)
+	}
+}
+
+func (r *restoreHandler) rekeyCategories(ct []category.Category) {
+	for i := range ct {
+		ct[i].RefID = r.remapID(ct[i].RefID)
+		ct[i].SpaceID = r.remapID(ct[i].SpaceID)
+	}
+}
+
+func (r *restoreHandler) rekeyCategoryMembers(cm []category.Member) {
+	for i := range cm {
+		cm[i].RefID = r.remapID(cm[i].RefID)
+		cm[i].CategoryID = r.remapID(cm[i].CategoryID)
+		cm[i].SpaceID = r.remapID(cm[i].SpaceID)
+		cm[i].DocumentID = r.remapID(cm[i].DocumentID)
+	}
+}
+
+func (r *restoreHandler) rekeyGroups(gr []group.Group) {
+	for i := range gr {
+		gr[i].RefID = r.remapID(gr[i].RefID)
+	}
+}
+
+func (r *restoreHandler) rekeyGroupMembers(gm []group.Member) {
+	for i := range gm {
+		gm[i].GroupID = r.remapID(gm[i].GroupID)
+	}
+}
+
+func (r *restoreHandler) rekeyPermissions(pm []permission.Permission) {
+	for i := range pm {
+		pm[i].WhoID = r.remapID(pm[i].WhoID)
+		pm[i].RefID = r.remapID(pm[i].RefID)
+	}
+}
+
+func (r *restoreHandler) rekeyPins(p []pin.Pin) {
+	for i := range p {
+		p[i].RefID = r.remapID(p[i].RefID)
+		p[i].SpaceID = r.remapID(p[i].SpaceID)
+		p[i].DocumentID = r.remapID(p[i].DocumentID)
+	}
+}
+
+func (r *restoreHandler) rekeySections(sc []page.Page) {
+	for i := range sc {
+		sc[i].RefID = r.remapID(sc[i].RefID)
+		sc[i].DocumentID = r.remapID(sc[i].DocumentID)
+		sc[i].TemplateID = r.remapID(sc[i].TemplateID)
+		sc[i].RelativeID = r.remapID(sc[i].RelativeID)
+	}
+}
+
+func (r *restoreHandler) rekeySectionMeta(sm []page.Meta) {
+	for i := range sm {
+		sm[i].SectionID = r.remapID(sm[i].SectionID)
+		sm[i].DocumentID = r.remapID(sm[i].DocumentID)
+	}
+}
+
+func (r *restoreHandler) rekeyRevisions(sr []page.Revision) {
+	for i := range sr {
+		sr[i].RefID = r.remapID(sr[i].RefID)
+		sr[i].DocumentID = r.remapID(sr[i].DocumentID)
+		sr[i].SectionID = r.remapID(sr[i].SectionID)
+	}
+}
+
+func (r *restoreHandler) rekeyTemplates(st []block.Block) {
+	for i := range st {
+		st[i].RefID = r.remapID(st[i].RefID)
+		st[i].SpaceID = r.remapID(st[i].SpaceID)
+	}
+}
+
+func (r *restoreHandler) rekeyDocuments(d []doc.Document) {
+	for i := range d {
+		d[i].RefID = r.remapID(d[i].RefID)
+		d[i].SpaceID = r.remapID(d[i].SpaceID)
+	}
+}
+
+func (r *restoreHandler) rekeyVotes(v []vote) {
+	for i := range v {
+		v[i].RefID = r.remapID(v[i].RefID)
+		v[i].DocumentID = r.remapID(v[i].DocumentID)
+	}
+}
+
+func (r *restoreHandler) rekeyLinks(lk []link.Link) {
+	for i := range lk {
+		lk[i].RefID = r.remapID(lk[i].RefID)
+		lk[i].SpaceID = r.remapID(lk[i].SpaceID)
+		lk[i].SourceDocumentID = r.remapID(lk[i].SourceDocumentID)
+		lk[i].SourceSectionID = r.remapID(lk[i].SourceSectionID)
+		lk[i].TargetDocumentID = r.remapID(lk[i].TargetDocumentID)
+		lk[i].TargetID = r.remapID(lk[i].TargetID)
+	}
+}
+
+func (r *restoreHandler) rekeyAttachments(at []attachment.Attachment) {
+	for i := range at {
+		at[i].RefID = r.remapID(at[i].RefID)
+		at[i].DocumentID = r.remapID(at[i].DocumentID)
+		at[i].SectionID = r.remapID(at[i].SectionID)
+	}
+}
+
+func (r *restoreHandler) rekeyComments(cm []comment) {
+	for i := range cm {
+		cm[i].RefID = r.remapID(cm[i].RefID)
+		cm[i].DocumentID = r.remapID(cm[i].DocumentID)
+		cm[i].SectionID = r.remapID(cm[i].SectionID)
+		cm[i].ReplyTo = r.remapID(cm[i].ReplyTo)
+	}
+}
+
+func (r *restoreHandler) rekeyActions(ac []action.UserAction) {
+	for i := range ac {
+		ac[i].RefID = r.remapID(ac[i].RefID)
+		ac[i].DocumentID = r.remapID(ac[i].DocumentID)
+		ac[i].RefTypeID = r.remapID(ac[i].RefTypeID)
+	}
+}
+
+func (r *restoreHandler) rekeyActivity(ac []activity.UserActivity) {
+	for i := range ac {
+		ac[i].SpaceID = r.remapID(ac[i].SpaceID)
+		ac[i].DocumentID = r.remapID(ac[i].DocumentID)
+		ac[i].SectionID = r.remapID(ac[i].SectionID)
+	}
+}
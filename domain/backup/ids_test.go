@@ -0,0 +1,112 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"archive/zip"
+	"testing"
+
+	"github.com/documize/community/model/doc"
+	"github.com/documize/community/model/link"
+	"github.com/documize/community/model/page"
+	"github.com/documize/community/model/space"
+)
+
+// Loads small backup holding one space, document, section and link.
+func idTestHandler(t *testing.T) (r *restoreHandler, sp []space.Space, d []doc.Document, sc []page.Page, lk []link.Link) {
+	sp = []space.Space{{Name: "Docs"}}
+	sp[0].RefID = "s1"
+	d = []doc.Document{{SpaceID: "s1", Name: "Guide"}}
+	d[0].RefID = "d1"
+	sc = []page.Page{{DocumentID: "d1"}}
+	sc[0].RefID = "p1"
+	lk = []link.Link{{SpaceID: "s1", SourceDocumentID: "d1", SourceSectionID: "p1", TargetDocumentID: "d1", TargetID: "external"}}
+	lk[0].RefID = "l1"
+
+	var files []backupItem
+	for name, v := range map[string]interface{}{
+		"dmz_space.json":    sp,
+		"dmz_doc.json":      d,
+		"dmz_section.json":  sc,
+		"dmz_doc_link.json": lk,
+	} {
+		c, err := toJSON(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, backupItem{Filename: name, Content: c})
+	}
+
+	zr, err := zip.OpenReader(writeTestZip(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { zr.Close() })
+
+	r = &restoreHandler{Zip: &zr.Reader, MapID: make(map[string]string)}
+	return
+}
+
+func TestPreserveIDs(t *testing.T) {
+	r, sp, d, sc, lk := idTestHandler(t)
+
+	r.rekeySpaces(sp)
+	r.rekeyDocuments(d)
+	r.rekeySections(sc)
+	r.rekeyLinks(lk)
+
+	if sp[0].RefID != "s1" || d[0].RefID != "d1" || d[0].SpaceID != "s1" || sc[0].DocumentID != "d1" || lk[0].SourceSectionID != "p1" {
+		t.Error("expected IDs to be preserved")
+	}
+}
+
+func TestRegenerateIDs(t *testing.T) {
+	r, sp, d, sc, lk := idTestHandler(t)
+
+	ids, err := r.backupIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids["dmz_doc"]) != 1 || ids["dmz_doc"][0] != "d1" {
+		t.Errorf("expected document ID d1 got %v", ids["dmz_doc"])
+	}
+	r.mintIDs(ids)
+	if len(r.MapID) != 4 {
+		t.Errorf("expected 4 new IDs got %d", len(r.MapID))
+	}
+
+	r.rekeySpaces(sp)
+	r.rekeyDocuments(d)
+	r.rekeySections(sc)
+	r.rekeyLinks(lk)
+
+	if sp[0].RefID == "s1" || d[0].RefID == "d1" || sc[0].RefID == "p1" || lk[0].RefID == "l1" {
+		t.Error("expected new IDs")
+	}
+
+	// References must follow the rows they point to.
+	if d[0].SpaceID != sp[0].RefID {
+		t.Errorf("document space %s does not match %s", d[0].SpaceID, sp[0].RefID)
+	}
+	if sc[0].DocumentID != d[0].RefID {
+		t.Errorf("section document %s does not match %s", sc[0].DocumentID, d[0].RefID)
+	}
+	if lk[0].SpaceID != sp[0].RefID || lk[0].SourceDocumentID != d[0].RefID ||
+		lk[0].TargetDocumentID != d[0].RefID || lk[0].SourceSectionID != sc[0].RefID {
+		t.Errorf("link references not remapped %+v", lk[0])
+	}
+
+	// IDs outside backup are left alone.
+	if lk[0].TargetID != "external" {
+		t.Errorf("expected external got %s", lk[0].TargetID)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Backup progress is reported to callers who open the progress stream
+// before starting backup, both using the same caller chosen ID:
+//
+//   GET  global/backup/progress?id=xyz  (server-sent events)
+//   POST global/backup?progress=xyz
+//
+// Each exported table produces one event carrying running totals,
+// followed by a final event marked complete.
+
+import (
+	"strings"
+	"sync"
+)
+
+// progressEvent describes backup progress so far.
+type progressEvent struct {
+	Table    string `json:"table,omitempty"`
+	Rows     int    `json:"rows"`
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	Complete bool   `json:"complete"`
+	Error    string `json:"error,omitempty"`
+}
+
+// progressFeed carries events from backup to one listener.
+// Methods are safe to call on nil feed, which reports nothing.
+type progressFeed struct {
+	events chan progressEvent
+	gone   chan struct{}
+	once   sync.Once
+	done   int
+	total  int
+}
+
+var (
+	feedsMu sync.Mutex
+	feeds   = make(map[string]*progressFeed)
+)
+
+// Scopes feed to user so that others cannot listen in.
+func feedKey(userID, id string) string {
+	return userID + "/" + id
+}
+
+// watchProgress registers listener for backup progress.
+// Caller must call unwatch once done listening.
+func watchProgress(userID, id string) *progressFeed {
+	f := &progressFeed{events: make(chan progressEvent, 16), gone: make(chan struct{})}
+
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	feeds[feedKey(userID, id)] = f
+
+	return f
+}
+
+// unwatch removes listener so that backup stops reporting.
+func (f *progressFeed) unwatch(userID, id string) {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	if feeds[feedKey(userID, id)] == f {
+		delete(feeds, feedKey(userID, id))
+	}
+	f.once.Do(func() { close(f.gone) })
+}
+
+// progressFor returns feed with listener waiting, if any.
+func progressFor(userID, id string) *progressFeed {
+	if len(strings.TrimSpace(id)) == 0 {
+		return nil
+	}
+
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+
+	return feeds[feedKey(userID, id)]
+}
+
+// send delivers event unless listener has gone away.
+func (f *progressFeed) send(e progressEvent) {
+	if f == nil {
+		return
+	}
+
+	select {
+	case f.events <- e:
+	case <-f.gone:
+	}
+}
+
+// start records number of tables backup will export.
+func (f *progressFeed) start(total int) {
+	if f == nil {
+		return
+	}
+	f.total = total
+	f.send(progressEvent{Total: total})
+}
+
+// table records table as exported.
+func (f *progressFeed) table(name string, rows int) {
+	if f == nil {
+		return
+	}
+	f.done++
+	f.send(progressEvent{Table: name, Rows: rows, Done: f.done, Total: f.total})
+}
+
+// finish sends final event, carrying error if backup failed.
+func (f *progressFeed) finish(err error) {
+	if f == nil {
+		return
+	}
+	e := progressEvent{Done: f.done, Total: f.total, Complete: true}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	f.send(e)
+}
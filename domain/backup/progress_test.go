@@ -0,0 +1,104 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressStream(t *testing.T) {
+	h := Handler{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/global/backup/progress?id=p1", nil)
+
+	stopped := make(chan struct{})
+	go func() {
+		h.BackupProgress(w, r)
+		close(stopped)
+	}()
+
+	// Backup only reports once listener is waiting.
+	var feed *progressFeed
+	for i := 0; i < 100 && feed == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		feed = progressFor("", "p1")
+	}
+	if feed == nil {
+		t.Fatal("listener not registered")
+	}
+	if progressFor("someone-else", "p1") != nil {
+		t.Error("feed visible to other user")
+	}
+
+	feed.start(3)
+	feed.table("dmz_org", 1)
+	feed.table("dmz_user", 12)
+	feed.table("dmz_doc", 40)
+	feed.finish(nil)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after completion")
+	}
+
+	var events []progressEvent
+	sc := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for sc.Scan() {
+		if !strings.HasPrefix(sc.Text(), "data: ") {
+			continue
+		}
+		var e progressEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(sc.Text(), "data: ")), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events got %d", len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Done < events[i-1].Done || events[i].Total != 3 {
+			t.Errorf("progress not monotonic at %d: %+v", i, events[i])
+		}
+	}
+	last := events[len(events)-1]
+	if !last.Complete || last.Done != last.Total || len(last.Error) > 0 {
+		t.Errorf("unexpected final event %+v", last)
+	}
+
+	// Listener is removed once stream ends.
+	if progressFor("", "p1") != nil {
+		t.Error("expected feed to be removed")
+	}
+}
+
+func TestProgressFeedNil(t *testing.T) {
+	var f *progressFeed
+	f.start(1)
+	f.table("dmz_org", 1)
+	f.finish(errors.New("failed"))
+
+	// Sending to departed listener must not block backup.
+	f = watchProgress("u", "gone")
+	f.unwatch("u", "gone")
+	for i := 0; i < 100; i++ {
+		f.table("dmz_doc", i)
+	}
+}
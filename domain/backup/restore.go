@@ -22,10 +22,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/documize/community/core/env"
+	"github.com/documize/community/core/osutil"
+	"github.com/documize/community/core/uniqueid"
 	"github.com/documize/community/domain"
 	"github.com/documize/community/domain/store"
 	"github.com/documize/community/model/account"
@@ -44,6 +47,7 @@ import (
 	"github.com/documize/community/model/permission"
 	"github.com/documize/community/model/pin"
 	"github.com/documize/community/model/space"
+	"github.com/documize/community/model/user"
 	"github.com/pkg/errors"
 )
 
@@ -56,6 +60,35 @@ type restoreHandler struct {
 	Zip       *zip.Reader
 	MapOrgID  map[string]string
 	MapUserID map[string]string
+
+	// Merge restore row counts keyed by table name.
+	MergedRows  map[string]int
+	SkippedRows map[string]int
+
+	// Rows dropped because referenced user or group does not exist.
+	DroppedRows map[string]int
+
+	// Documents written by restore, used for scoped search reindex.
+	Documents map[string]bool
+
+	// New names for spaces and categories whose slug clashed, keyed by ID.
+	Renamed map[string]string
+
+	// Regenerated entity IDs keyed by ID found in backup file.
+	MapID map[string]string
+
+	// Identifies backup file for resumable restores.
+	Checksum string
+
+	// Tables restored and any row count mismatches found afterwards.
+	Restored      []string
+	Discrepancies []string
+}
+
+// Restore step for a single backup table.
+type restoreStep struct {
+	table   string
+	restore func() error
 }
 
 // During the restore process, it may be necessary to change
@@ -77,9 +110,18 @@ func (r *restoreHandler) remapUser(id string) string {
 	return id
 }
 
-// PerformRestore will unzip backup file and verify contents
-// are suitable for restore operation.
-func (r *restoreHandler) PerformRestore(b []byte, l int64) (err error) {
+// Validate opens backup file and checks it holds everything
+// its manifest describes, without touching the database.
+func (r *restoreHandler) Validate(b []byte, l int64) (err error) {
+	// Encrypted backups are opened before anything else.
+	if isEncrypted(b) {
+		b, err = openArchive(b, r.Spec.Passphrase, r.Spec.PassphraseRef)
+		if err != nil {
+			return
+		}
+		l = int64(len(b))
+	}
+
 	// Read zip file into handler for subsequent processing.
 	z, err := zip.NewReader(bytes.NewReader(b), l)
 	if err != nil {
@@ -87,6 +129,7 @@ func (r *restoreHandler) PerformRestore(b []byte, l int64) (err error) {
 		return
 	}
 	r.Zip = z
+	r.Checksum = archiveChecksum(b)
 
 	// Unpack manifest for backup host details.
 	err = r.manifest()
@@ -94,173 +137,395 @@ func (r *restoreHandler) PerformRestore(b []byte, l int64) (err error) {
 		return
 	}
 
-	// Detect system backup file.
-	r.Spec.GlobalBackup = (r.Spec.Manifest.OrgID == "*")
-	// If user is not Global Admin then you cannot do system restore.
-	if !r.Context.GlobalAdmin {
-		r.Spec.GlobalBackup = false
-	}
-
-	// Process might require reassignment of ID values.
-	r.MapOrgID = make(map[string]string)
-	r.MapUserID = make(map[string]string)
+	// Truncated or tampered archives must not reach the database.
+	return r.verifyArchive()
+}
 
-	// Organization.
-	err = r.dmzOrg()
+// PerformRestore will unzip backup file and verify contents
+// are suitable for restore operation.
+func (r *restoreHandler) PerformRestore(b []byte, l int64) (err error) {
+	err = r.Validate(b, l)
 	if err != nil {
 		return
 	}
 
-	// User.
-	err = r.dmzUser()
+	// Fail early if we cannot hold the uncompressed archive.
+	err = r.preflight()
 	if err != nil {
 		return
 	}
 
-	// User Account.
-	err = r.dmzUserAccount()
-	if err != nil {
+	// Space backup holds subset of tenant data so must not replace the rest.
+	if len(r.Spec.Manifest.SpaceID) > 0 && !r.Spec.Merge {
+		err = errors.New("space backup can only be restored using merge")
 		return
 	}
 
-	// User Activity.
-	err = r.dmzUserActivity()
-	if err != nil {
-		return
+	// Detect system backup file.
+	r.Spec.GlobalBackup = (r.Spec.Manifest.OrgID == "*")
+	// If user is not Global Admin then you cannot do system restore.
+	if !r.Context.GlobalAdmin {
+		r.Spec.GlobalBackup = false
 	}
 
-	// User Config.
-	err = r.dmzUserConfig()
+	// Report clashing space and category slugs before touching data.
+	err = r.checkSlugConflicts()
 	if err != nil {
 		return
 	}
 
-	// Config.
-	if r.Context.GlobalAdmin {
-		err = r.dmzConfig()
+	// Process might require reassignment of ID values.
+	r.MapOrgID = make(map[string]string)
+	r.MapUserID = make(map[string]string)
+	r.MergedRows = make(map[string]int)
+	r.SkippedRows = make(map[string]int)
+	r.DroppedRows = make(map[string]int)
+	r.Documents = make(map[string]bool)
+
+	// Check preserved IDs are free or mint replacements.
+	err = r.prepareIDs()
+	if err != nil {
+		return
+	}
+
+	// Tables are restored in order so that parent rows exist before children.
+	steps := []restoreStep{
+		{"dmz_org", r.dmzOrg},
+		{"dmz_user", r.dmzUser},
+		{"dmz_user_account", r.dmzUserAccount},
+		{"dmz_user_activity", r.dmzUserActivity},
+		{"dmz_user_config", r.dmzUserConfig},
+		{"dmz_config", r.dmzConfig},
+		{"dmz_audit_log", r.dmzAudit},
+		{"dmz_action", r.dmzAction},
+		{"dmz_space_label", r.dmzSpaceLabel},
+		{"dmz_space", r.dmzSpace},
+		{"dmz_category", r.dmzCategory},
+		{"dmz_group", r.dmzGroup},
+		{"dmz_group_member", r.dmzGroupMember},
+		{"dmz_permission", r.dmzPermission},
+		{"dmz_pin", r.dmzPin},
+		{"dmz_section", r.dmzSection},
+		{"dmz_section_meta", r.dmzSectionMeta},
+		{"dmz_section_template", r.dmzSectionTemplate},
+		{"dmz_section_revision", r.dmzSectionRevision},
+		{"dmz_doc", r.dmzDoc},
+		{"dmz_category_member", r.dmzCategoryMember},
+		{"dmz_doc_vote", r.dmzDocVote},
+		{"dmz_doc_link", r.dmzDocLink},
+		{"dmz_doc_attachment", r.dmzDocAttachment},
+		{"dmz_doc_comment", r.dmzDocComment},
+		{"dmz_doc_share", r.dmzDocShare},
+	}
+
+	// Pick up where previous attempt with same backup file failed.
+	start := 0
+	if r.Spec.Resume {
+		if cp, ok := r.loadCheckpoint(); ok {
+			start = resumeIndex(steps, cp.Table)
+			r.MapOrgID = cp.MapOrgID
+			r.MapUserID = cp.MapUserID
+			r.MapID = cp.MapID
+			r.Runtime.Log.Info(fmt.Sprintf("Restore resuming after %s", cp.Table))
+		} else {
+			r.Runtime.Log.Info("Restore found no checkpoint to resume from, starting from beginning")
+		}
+	}
+
+	for _, step := range steps[start:] {
+		// Only Global Admin can restore system wide config.
+		if step.table == "dmz_config" && !r.Context.GlobalAdmin {
+			continue
+		}
+
+		// Tables intentionally left out of backup are left alone.
+		if r.Spec.Manifest.IsExcluded(step.table) {
+			r.Runtime.Log.Info(fmt.Sprintf("Restore skipped excluded table %s", step.table))
+			continue
+		}
+
+		if r.Spec.Merge && mergeSkipped[step.table] {
+			r.Runtime.Log.Info(fmt.Sprintf("Merge restore skipped %s", step.table))
+			continue
+		}
+
+		err = step.restore()
 		if err != nil {
 			return
 		}
-	}
+		r.Restored = append(r.Restored, step.table)
 
-	// Audit Log.
-	err = r.dmzAudit()
-	if err != nil {
-		return
+		r.saveCheckpoint(step.table)
 	}
 
-	// Action.
-	err = r.dmzAction()
+	r.clearCheckpoint()
+
+	// Compare what landed against what was exported.
+	r.Discrepancies, err = r.verifyCounts()
 	if err != nil {
 		return
 	}
+	for _, d := range r.Discrepancies {
+		r.Runtime.Log.Info("Restore discrepancy: " + d)
+	}
 
-	// Space Label.
-	err = r.dmzSpaceLabel()
-	if err != nil {
+	return nil
+}
+
+// Compares manifest row counts with rows now held in database.
+// Merge restores keep existing rows so we only expect at least
+// as many rows as the backup contained.
+// Backups made before row counts were recorded are not checked.
+func (r *restoreHandler) verifyCounts() (discrepancies []string, err error) {
+	if len(r.Spec.Manifest.Counts) == 0 {
 		return
 	}
 
-	// Space.
-	err = r.dmzSpace()
-	if err != nil {
+	// Restoring system backup as tenant restore does not map rows one-to-one.
+	if r.Spec.Manifest.OrgID == "*" && !r.Spec.GlobalBackup {
+		r.Runtime.Log.Info("Restore count verification skipped for system backup restored as tenant")
 		return
 	}
 
-	// Category.
-	err = r.dmzCategory()
-	if err != nil {
-		return
+	for _, table := range r.Restored {
+		expected, ok := r.Spec.Manifest.Counts[table]
+		if !ok {
+			continue
+		}
+		// Restore adds its own audit entry and may keep existing log.
+		if table == "dmz_audit_log" {
+			continue
+		}
+		// Rows deliberately dropped during restore are not expected.
+		expected -= r.DroppedRows[table]
+
+		var q string
+		switch {
+		case r.Spec.GlobalBackup:
+			// Users may be folded together when matched by email.
+			if table == "dmz_user" && r.Spec.MatchEmail {
+				continue
+			}
+			q = "SELECT COUNT(*) FROM " + table
+		case table == "dmz_org", table == "dmz_user", table == "dmz_config":
+			// Tenant restores update rather than replace these.
+			continue
+		default:
+			q = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE c_orgid='%s'", table, r.Spec.Org.RefID)
+		}
+
+		var actual int
+		err = r.Runtime.Db.Get(&actual, q)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("unable to count %s", table))
+			return
+		}
+
+		if d := countDiscrepancy(table, expected, actual, r.Spec.Merge); len(d) > 0 {
+			discrepancies = append(discrepancies, d)
+		}
 	}
 
-	// Category Member.
-	err = r.dmzCategoryMember()
-	if err != nil {
-		return
+	return
+}
+
+// Describes mismatch between expected and actual row count, if any.
+func countDiscrepancy(table string, expected, actual int, merge bool) string {
+	if merge && actual >= expected {
+		return ""
+	}
+	if !merge && actual == expected {
+		return ""
 	}
 
-	// Group.
-	err = r.dmzGroup()
-	if err != nil {
-		return
+	return fmt.Sprintf("%s expected %d rows, found %d", table, expected, actual)
+}
+
+// Removes existing table data ahead of import.
+// System restores truncate the table, tenant restores only remove
+// current organization rows and merge restores leave everything in place.
+func (r *restoreHandler) clear(table string) (err error) {
+	if r.Spec.Merge {
+		return nil
 	}
 
-	// Group Member.
-	err = r.dmzGroupMember()
-	if err != nil {
-		return
+	nuke := fmt.Sprintf("TRUNCATE TABLE %s", table)
+	if !r.Spec.GlobalBackup {
+		nuke = fmt.Sprintf("DELETE FROM %s WHERE c_orgid='%s'", table, r.Spec.Org.RefID)
 	}
+	_, err = r.Context.Transaction.Exec(nuke)
 
-	// Permission.
-	err = r.dmzPermission()
-	if err != nil {
-		return
+	return
+}
+
+// Checks user or group referenced by permission exists in target database.
+func (r *restoreHandler) permissionTargetExists(pm permission.Permission) (bool, error) {
+	switch pm.Who {
+	case permission.UserPermission:
+		if pm.WhoID == user.EveryoneUserID {
+			return true, nil
+		}
+		return r.exists("dmz_user", r.remapUser(pm.WhoID))
+	case permission.GroupPermission:
+		return r.exists("dmz_group", pm.WhoID)
 	}
 
-	// Pin.
-	err = r.dmzPin()
+	return true, nil
+}
+
+// Returns IDs of spaces, categories and documents held in backup file.
+func (r *restoreHandler) restoredRefs() (refs map[string]bool, err error) {
+	refs = make(map[string]bool)
+
+	sp := []space.Space{}
+	ct := []category.Category{}
+	d := []doc.Document{}
+	err = firstError(
+		r.fileJSON("dmz_space.json", &sp),
+		r.fileJSON("dmz_category.json", &ct),
+		r.fileJSON("dmz_doc.json", &d))
 	if err != nil {
 		return
 	}
 
-	// Section.
-	err = r.dmzSection()
-	if err != nil {
-		return
+	for i := range sp {
+		refs[r.remapID(sp[i].RefID)] = true
+	}
+	for i := range ct {
+		refs[r.remapID(ct[i].RefID)] = true
+	}
+	for i := range d {
+		refs[r.remapID(d[i].RefID)] = true
 	}
 
-	// Section Meta.
-	err = r.dmzSectionMeta()
-	if err != nil {
-		return
+	return
+}
+
+// Keeps permissions that target restored content and are not already
+// granted, compared on who, action and target.
+func (r *restoreHandler) mergePermissions(pm []permission.Permission, refs map[string]bool,
+	granted func(whoID string, action permission.Action, refID string) (bool, error)) (keep []permission.Permission, err error) {
+	seen := make(map[string]bool)
+
+	for i := range pm {
+		if !refs[pm[i].RefID] {
+			continue
+		}
+
+		whoID := r.remapUser(pm[i].WhoID)
+		key := whoID + "/" + string(pm[i].Action) + "/" + pm[i].RefID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var found bool
+		found, err = granted(whoID, pm[i].Action, pm[i].RefID)
+		if err != nil {
+			return
+		}
+		if !found {
+			keep = append(keep, pm[i])
+		}
 	}
 
-	// Section Template.
-	err = r.dmzSectionTemplate()
-	if err != nil {
-		return
+	return
+}
+
+// Checks permission exists within current restore transaction.
+func (r *restoreHandler) permissionGranted(whoID string, action permission.Action, refID string) (bool, error) {
+	var n int
+	err := r.Context.Transaction.Get(&n, r.Runtime.Db.Rebind(
+		"SELECT COUNT(*) FROM dmz_permission WHERE c_whoid=? AND c_action=? AND c_refid=?"),
+		whoID, string(action), refID)
+
+	return n > 0, err
+}
+
+// Checks row with given c_refid exists within current restore transaction.
+func (r *restoreHandler) exists(table, refID string) (bool, error) {
+	var n int
+	err := r.Context.Transaction.Get(&n, r.Runtime.Db.Rebind(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE c_refid=?", table)), refID)
+
+	return n > 0, err
+}
+
+// Merge decides if backup row should be written to the database.
+// Outside of merge mode every row is written.
+//
+// In merge mode we compare the backup row timestamp against the existing row.
+// Newer backup rows replace existing rows, otherwise the existing row is kept.
+// Rows that do not exist locally are always written.
+func (r *restoreHandler) merge(table, keyColumn, timeColumn, key string, ts time.Time) (apply bool, err error) {
+	if !r.Spec.Merge {
+		return true, nil
 	}
 
-	// Section Revision.
-	err = r.dmzSectionRevision()
+	var current time.Time
+	err = r.Context.Transaction.Get(&current, r.Runtime.Db.Rebind(
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s=?", timeColumn, table, keyColumn)), key)
+	if err == sql.ErrNoRows {
+		r.MergedRows[table]++
+		return true, nil
+	}
 	if err != nil {
-		return
+		return false, err
 	}
 
-	// Doc.
-	err = r.dmzDoc()
-	if err != nil {
-		return
+	if !ts.After(current) {
+		r.SkippedRows[table]++
+		return false, nil
 	}
 
-	// Doc Vote.
-	err = r.dmzDocVote()
+	_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(
+		fmt.Sprintf("DELETE FROM %s WHERE %s=?", table, keyColumn)), key)
 	if err != nil {
-		return
+		return false, err
 	}
 
-	// Doc Link.
-	err = r.dmzDocLink()
-	if err != nil {
-		return
+	r.MergedRows[table]++
+
+	return true, nil
+}
+
+// Tables without timestamps cannot be compared so merge restores
+// leave existing data untouched.
+var mergeSkipped = map[string]bool{
+	"dmz_config":        true,
+	"dmz_audit_log":     true,
+	"dmz_group_member":  true,
+	"dmz_doc_share":     true,
+	"dmz_user_activity": true,
+	"dmz_user_config":   true,
+}
+
+// Preflight compares the uncompressed size of the archive against
+// free space on the temp volume so that large restores do not fail midway.
+func (r *restoreHandler) preflight() (err error) {
+	var need uint64
+	for _, zf := range r.Zip.File {
+		need += zf.UncompressedSize64
 	}
 
-	// Doc Attachment.
-	err = r.dmzDocAttachment()
-	if err != nil {
-		return
+	dir := r.Runtime.Flags.BackupTempDir
+	if len(dir) == 0 {
+		dir = os.TempDir()
 	}
 
-	// Doc Comment.
-	err = r.dmzDocComment()
+	have, err := osutil.DiskFree(dir)
 	if err != nil {
-		return
+		// Not all platforms can report free space so we carry on.
+		r.Runtime.Log.Infof("Restore unable to check free disk space: %s", err.Error())
+		return nil
 	}
 
-	// Doc Share.
-	err = r.dmzDocShare()
-	if err != nil {
-		return
+	return checkDiskSpace(need, have)
+}
+
+// Returns error when required bytes exceed available bytes.
+func checkDiskSpace(need, have uint64) error {
+	if need > have {
+		return fmt.Errorf("insufficient disk space: need %d bytes, have %d bytes", need, have)
 	}
 
 	return nil
@@ -269,7 +534,7 @@ func (r *restoreHandler) PerformRestore(b []byte, l int64) (err error) {
 func (r *restoreHandler) manifest() (err error) {
 	found, zi, err := r.readZip("manifest.json")
 	if !found {
-		err = errors.Wrap(err, "missing manifest.json")
+		err = errors.New("missing manifest.json")
 		return
 	}
 	if err != nil {
@@ -307,6 +572,17 @@ func (r *restoreHandler) fileJSON(filename string, v interface{}) (err error) {
 	return nil
 }
 
+// Returns true if backup file contains named file.
+func (r *restoreHandler) hasFile(filename string) bool {
+	for _, zf := range r.Zip.File {
+		if zf.Name == filename {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Fetches file from zip reader.
 func (r *restoreHandler) readZip(filename string) (found bool, b []byte, err error) {
 	found = false
@@ -358,7 +634,7 @@ func (r *restoreHandler) dmzOrg() (err error) {
 	// the one in the backup file, ensuring correct data linkage.
 	if r.Spec.GlobalBackup {
 		// Nuke all existing data.
-		_, err = r.Context.Transaction.Exec("TRUNCATE TABLE dmz_org")
+		err = r.clear("dmz_org")
 		if err != nil {
 			r.Context.Transaction.Rollback()
 			err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -366,6 +642,16 @@ func (r *restoreHandler) dmzOrg() (err error) {
 		}
 
 		for i := range org {
+			apply, e := r.merge("dmz_org", "c_refid", "c_revised", org[i].RefID, org[i].Revised)
+			if e != nil {
+				r.Context.Transaction.Rollback()
+				err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, org[i].RefID))
+				return
+			}
+			if !apply {
+				continue
+			}
+
 			_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
                 INSERT INTO dmz_org (c_refid, c_company, c_title, c_message,
                 c_domain, c_service, c_email, c_anonaccess, c_authprovider, c_authconfig,
@@ -399,7 +685,8 @@ func (r *restoreHandler) dmzOrg() (err error) {
 		org[0].RefID = r.remapOrg(org[0].RefID) // e.g. remap orgID
 
 		// Update org settings if allowed to do so.
-		if !r.Spec.OverwriteOrg {
+		// Space backups never carry them.
+		if !r.Spec.OverwriteOrg || len(r.Spec.Manifest.SpaceID) > 0 {
 			org[0].AllowAnonymousAccess = r.Spec.Org.AllowAnonymousAccess
 			org[0].AuthProvider = r.Spec.Org.AuthProvider
 			org[0].AuthConfig = r.Spec.Org.AuthConfig
@@ -487,6 +774,13 @@ func (r *restoreHandler) dmzConfig() (err error) {
 func (r *restoreHandler) dmzAudit() (err error) {
 	filename := "dmz_audit_log.json"
 
+	// Audit log is only in backups taken with IncludeAudit
+	// and only replaces existing log when requested.
+	if !r.Spec.IncludeAudit || !r.hasFile(filename) {
+		r.Runtime.Log.Info(fmt.Sprintf("Restore kept existing %s", filename))
+		return r.recordRestore()
+	}
+
 	log := []audit.AppEvent{}
 	err = r.fileJSON(filename, &log)
 	if err != nil {
@@ -503,20 +797,17 @@ func (r *restoreHandler) dmzAudit() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_audit_log"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_audit_log WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_audit_log")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_audit_log", "c_orgid, c_userid, c_eventtype, c_ip, c_created")
+
 	for i := range log {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind("INSERT INTO dmz_audit_log (c_orgid, c_userid, c_eventtype, c_ip, c_created) VALUES (?, ?, ?, ?, ?)"),
-			r.remapOrg(log[i].OrgID), r.remapUser(log[i].UserID), log[i].Type, log[i].IP, log[i].Created)
+		err = batch.add(r.remapOrg(log[i].OrgID), r.remapUser(log[i].UserID), log[i].Type, log[i].IP, log[i].Created)
 		if err != nil {
 			r.Context.Transaction.Rollback()
 			err = errors.Wrap(err, fmt.Sprintf("unable to insert %s %d", filename, log[i].ID))
@@ -524,7 +815,14 @@ func (r *restoreHandler) dmzAudit() (err error) {
 		}
 	}
 
-	_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind("INSERT INTO dmz_audit_log (c_orgid, c_userid, c_eventtype, c_ip, c_created) VALUES (?, ?, ?, ?, ?)"),
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
+	_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(restoreEventSQL),
 		r.Context.OrgID, r.Context.UserID, "restored-database", r.Context.ClientIP, time.Now().UTC())
 
 	err = r.Context.Transaction.Commit()
@@ -539,6 +837,19 @@ func (r *restoreHandler) dmzAudit() (err error) {
 	return nil
 }
 
+const restoreEventSQL = "INSERT INTO dmz_audit_log (c_orgid, c_userid, c_eventtype, c_ip, c_created) VALUES (?, ?, ?, ?, ?)"
+
+// Records restore operation in audit log.
+func (r *restoreHandler) recordRestore() (err error) {
+	_, err = r.Runtime.Db.Exec(r.Runtime.Db.Rebind(restoreEventSQL),
+		r.Context.OrgID, r.Context.UserID, "restored-database", r.Context.ClientIP, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "unable to record restore in audit log")
+	}
+
+	return
+}
+
 // Action.
 func (r *restoreHandler) dmzAction() (err error) {
 	filename := "dmz_action.json"
@@ -549,6 +860,7 @@ func (r *restoreHandler) dmzAction() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyActions(ac)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -559,20 +871,27 @@ func (r *restoreHandler) dmzAction() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_action"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_action WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_action")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_action", "c_refid, c_orgid, c_userid, c_docid, c_actiontype, c_note, c_requestorid, c_requested, c_due, c_reftype, c_reftypeid")
+
 	for i := range ac {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind("INSERT INTO dmz_action (c_refid, c_orgid, c_userid, c_docid, c_actiontype, c_note, c_requestorid, c_requested, c_due, c_reftype, c_reftypeid) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
-			ac[i].RefID, r.remapOrg(ac[i].OrgID), r.remapUser(ac[i].UserID), ac[i].DocumentID, ac[i].ActionType, ac[i].Note, ac[i].RequestorID, ac[i].Requested, ac[i].Due, ac[i].RefType, ac[i].RefTypeID)
+		apply, e := r.merge("dmz_action", "c_refid", "c_revised", ac[i].RefID, ac[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, ac[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(ac[i].RefID, r.remapOrg(ac[i].OrgID), r.remapUser(ac[i].UserID), ac[i].DocumentID, ac[i].ActionType, ac[i].Note, ac[i].RequestorID, ac[i].Requested, ac[i].Due, ac[i].RefType, ac[i].RefTypeID)
 		if err != nil {
 			r.Context.Transaction.Rollback()
 			err = errors.Wrap(err, fmt.Sprintf("unable to insert %s %s", filename, ac[i].RefID))
@@ -580,6 +899,13 @@ func (r *restoreHandler) dmzAction() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -602,6 +928,7 @@ func (r *restoreHandler) dmzSpaceLabel() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyLabels(label)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -612,11 +939,7 @@ func (r *restoreHandler) dmzSpaceLabel() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_space_label"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_space_label WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_space_label")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -624,6 +947,16 @@ func (r *restoreHandler) dmzSpaceLabel() (err error) {
 	}
 
 	for i := range label {
+		apply, e := r.merge("dmz_space_label", "c_refid", "c_revised", label[i].RefID, label[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, label[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_space_label
             (c_refid, c_orgid, c_name, c_color, c_created, c_revised)
@@ -660,6 +993,7 @@ func (r *restoreHandler) dmzSpace() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeySpaces(sp)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -670,11 +1004,7 @@ func (r *restoreHandler) dmzSpace() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_space"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_space WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_space")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -682,13 +1012,23 @@ func (r *restoreHandler) dmzSpace() (err error) {
 	}
 
 	for i := range sp {
+		apply, e := r.merge("dmz_space", "c_refid", "c_revised", sp[i].RefID, sp[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, sp[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_space
                 (c_refid, c_name, c_orgid, c_userid, c_type, c_lifecycle,
                 c_likes, c_icon, c_desc, c_count_category, c_count_content,
                 c_labelid, c_created, c_revised)
                 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			sp[i].RefID, sp[i].Name, r.remapOrg(sp[i].OrgID),
+			sp[i].RefID, r.rename(sp[i].RefID, sp[i].Name), r.remapOrg(sp[i].OrgID),
 			r.remapUser(sp[i].UserID), sp[i].Type, sp[i].Lifecycle,
 			sp[i].Likes, sp[i].Icon, sp[i].Description, sp[i].CountCategory,
 			sp[i].CountContent, sp[i].LabelID, sp[i].Created, sp[i].Revised)
@@ -722,6 +1062,7 @@ func (r *restoreHandler) dmzCategory() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyCategories(ct)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -732,22 +1073,36 @@ func (r *restoreHandler) dmzCategory() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_category"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_category WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_category")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	// Orphans are dropped before merge so existing rows are not replaced by them.
+	ct, err = r.categoriesWithSpace(ct, r.exists)
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to check %s", filename))
+		return
+	}
+
 	for i := range ct {
+		apply, e := r.merge("dmz_category", "c_refid", "c_revised", ct[i].RefID, ct[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, ct[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_category (c_refid, c_orgid, c_spaceid, c_name, c_default, c_created, c_revised)
             VALUES (?, ?, ?, ?, ?, ?, ?)`),
-			ct[i].RefID, r.remapOrg(ct[i].OrgID), ct[i].SpaceID, ct[i].Name, ct[i].IsDefault, ct[i].Created, ct[i].Revised)
+			ct[i].RefID, r.remapOrg(ct[i].OrgID), ct[i].SpaceID, r.rename(ct[i].RefID, ct[i].Name), ct[i].IsDefault, ct[i].Created, ct[i].Revised)
 
 		if err != nil {
 			r.Context.Transaction.Rollback()
@@ -778,6 +1133,7 @@ func (r *restoreHandler) dmzCategoryMember() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyCategoryMembers(cm)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -788,23 +1144,35 @@ func (r *restoreHandler) dmzCategoryMember() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_category_member"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_category_member WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_category_member")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_category_member", "c_refid, c_orgid, c_categoryid, c_spaceid, c_docid, c_created, c_revised")
+
+	// Orphans are dropped before merge so existing rows are not replaced by them.
+	cm, err = r.membersWithParents(cm, r.exists)
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to check %s", filename))
+		return
+	}
+
 	for i := range cm {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_category_member
-            (c_refid, c_orgid, c_categoryid, c_spaceid, c_docid, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?)`),
-			cm[i].RefID, r.remapOrg(cm[i].OrgID), cm[i].CategoryID, cm[i].SpaceID, cm[i].DocumentID, cm[i].Created, cm[i].Revised)
+		apply, e := r.merge("dmz_category_member", "c_refid", "c_revised", cm[i].RefID, cm[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, cm[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(cm[i].RefID, r.remapOrg(cm[i].OrgID), cm[i].CategoryID, cm[i].SpaceID, cm[i].DocumentID, cm[i].Created, cm[i].Revised)
 
 		if err != nil {
 			r.Context.Transaction.Rollback()
@@ -813,6 +1181,13 @@ func (r *restoreHandler) dmzCategoryMember() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -825,6 +1200,49 @@ func (r *restoreHandler) dmzCategoryMember() (err error) {
 	return nil
 }
 
+// Categories for spaces that did not make it are dropped.
+func (r *restoreHandler) categoriesWithSpace(ct []category.Category,
+	exists func(table, refID string) (bool, error)) (keep []category.Category, err error) {
+	for i := range ct {
+		var found bool
+		found, err = exists("dmz_space", ct[i].SpaceID)
+		if err != nil {
+			return
+		}
+		if !found {
+			r.Runtime.Log.Infof("Restore dropped category %s for missing space %s", ct[i].RefID, ct[i].SpaceID)
+			r.DroppedRows["dmz_category"]++
+			continue
+		}
+		keep = append(keep, ct[i])
+	}
+
+	return
+}
+
+// Links to categories or documents that did not make it are dropped.
+func (r *restoreHandler) membersWithParents(cm []category.Member,
+	exists func(table, refID string) (bool, error)) (keep []category.Member, err error) {
+	for i := range cm {
+		var found bool
+		found, err = exists("dmz_category", cm[i].CategoryID)
+		if err == nil && found {
+			found, err = exists("dmz_doc", cm[i].DocumentID)
+		}
+		if err != nil {
+			return
+		}
+		if !found {
+			r.Runtime.Log.Infof("Restore dropped category member %s for missing category %s or document %s", cm[i].RefID, cm[i].CategoryID, cm[i].DocumentID)
+			r.DroppedRows["dmz_category_member"]++
+			continue
+		}
+		keep = append(keep, cm[i])
+	}
+
+	return
+}
+
 // Group.
 func (r *restoreHandler) dmzGroup() (err error) {
 	filename := "dmz_group.json"
@@ -835,6 +1253,7 @@ func (r *restoreHandler) dmzGroup() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyGroups(gr)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -843,13 +1262,9 @@ func (r *restoreHandler) dmzGroup() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("unable to start TX for %s", filename))
 		return
 	}
-
-	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_group"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_group WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+
+	// Nuke all existing data.
+	err = r.clear("dmz_group")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -857,6 +1272,16 @@ func (r *restoreHandler) dmzGroup() (err error) {
 	}
 
 	for i := range gr {
+		apply, e := r.merge("dmz_group", "c_refid", "c_revised", gr[i].RefID, gr[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, gr[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_group
             (c_refid, c_orgid, c_name, c_desc, c_created, c_revised)
@@ -892,6 +1317,7 @@ func (r *restoreHandler) dmzGroupMember() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyGroupMembers(gm)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -902,11 +1328,7 @@ func (r *restoreHandler) dmzGroupMember() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_group_member"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_group_member WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_group_member")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -949,33 +1371,62 @@ func (r *restoreHandler) dmzPermission() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyPermissions(pm)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
+	// Merge restores only bring back permissions for restored content.
+	var refs map[string]bool
+	if r.Spec.Merge {
+		refs, err = r.restoredRefs()
+		if err != nil {
+			return
+		}
+	}
+
 	r.Context.Transaction, err = r.Runtime.Db.Beginx()
 	if err != nil {
 		err = errors.Wrap(err, fmt.Sprintf("unable to start TX for %s", filename))
 		return
 	}
 
-	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_permission"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_permission WHERE c_orgid='%s'", r.Spec.Org.RefID)
+	if r.Spec.Merge {
+		total := len(pm)
+		pm, err = r.mergePermissions(pm, refs, r.permissionGranted)
+		if err != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(err, fmt.Sprintf("unable to merge %s", filename))
+			return
+		}
+		r.SkippedRows["dmz_permission"] += total - len(pm)
 	}
-	_, err = r.Context.Transaction.Exec(nuke)
+
+	// Nuke all existing data.
+	err = r.clear("dmz_permission")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_permission", "c_orgid, c_who, c_whoid, c_action, c_scope, c_location, c_refid, c_created")
+
 	for i := range pm {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_permission
-            (c_orgid, c_who, c_whoid, c_action, c_scope, c_location, c_refid, c_created)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
-			r.remapOrg(pm[i].OrgID), string(pm[i].Who), r.remapUser(pm[i].WhoID),
+		// Permissions for users or groups that did not make it are dropped.
+		var found bool
+		found, err = r.permissionTargetExists(pm[i])
+		if err != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(err, fmt.Sprintf("unable to check %s %s", filename, pm[i].WhoID))
+			return
+		}
+		if !found {
+			r.Runtime.Log.Infof("Restore dropped permission for missing %s %s", pm[i].Who, pm[i].WhoID)
+			r.DroppedRows["dmz_permission"]++
+			continue
+		}
+
+		err = batch.add(r.remapOrg(pm[i].OrgID), string(pm[i].Who), r.remapUser(pm[i].WhoID),
 			string(pm[i].Action), string(pm[i].Scope),
 			string(pm[i].Location), pm[i].RefID, pm[i].Created)
 
@@ -984,6 +1435,18 @@ func (r *restoreHandler) dmzPermission() (err error) {
 			err = errors.Wrap(err, fmt.Sprintf("unable to insert %s %s", filename, pm[i].WhoID))
 			return
 		}
+
+		// Only permissions actually written count as merged.
+		if r.Spec.Merge {
+			r.MergedRows["dmz_permission"]++
+		}
+	}
+
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
 	}
 
 	err = r.Context.Transaction.Commit()
@@ -1008,6 +1471,7 @@ func (r *restoreHandler) dmzPin() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyPins(pin)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1018,23 +1482,27 @@ func (r *restoreHandler) dmzPin() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_pin"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_pin WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_pin")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_pin", "c_refid, c_orgid, c_userid, c_spaceid, c_docid, c_name, c_sequence, c_created, c_revised")
+
 	for i := range pin {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_pin
-            (c_refid, c_orgid, c_userid, c_spaceid, c_docid, c_name, c_sequence, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			pin[i].RefID, r.remapOrg(pin[i].OrgID), r.remapUser(pin[i].UserID), pin[i].SpaceID,
+		apply, e := r.merge("dmz_pin", "c_refid", "c_revised", pin[i].RefID, pin[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, pin[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(pin[i].RefID, r.remapOrg(pin[i].OrgID), r.remapUser(pin[i].UserID), pin[i].SpaceID,
 			pin[i].DocumentID, pin[i].Name, pin[i].Sequence, pin[i].Created, pin[i].Revised)
 
 		if err != nil {
@@ -1044,6 +1512,13 @@ func (r *restoreHandler) dmzPin() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1066,6 +1541,7 @@ func (r *restoreHandler) dmzSection() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeySections(sc)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1076,24 +1552,28 @@ func (r *restoreHandler) dmzSection() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_section"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_section WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_section")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_section", "c_refid, c_orgid, c_docid, c_userid, c_contenttype, c_type, c_level, c_name, c_body, c_revisions, c_sequence, c_templateid, c_status, c_relativeid, c_created, c_revised")
+
 	for i := range sc {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_section
-            (c_refid, c_orgid, c_docid, c_userid, c_contenttype, c_type, c_level, c_name, c_body,
-            c_revisions, c_sequence, c_templateid, c_status, c_relativeid, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			sc[i].RefID, r.remapOrg(sc[i].OrgID), sc[i].DocumentID, r.remapUser(sc[i].UserID),
+		apply, e := r.merge("dmz_section", "c_refid", "c_revised", sc[i].RefID, sc[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, sc[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+		r.Documents[sc[i].DocumentID] = true
+
+		err = batch.add(sc[i].RefID, r.remapOrg(sc[i].OrgID), sc[i].DocumentID, r.remapUser(sc[i].UserID),
 			sc[i].ContentType, sc[i].Type, sc[i].Level, sc[i].Name,
 			sc[i].Body, sc[i].Revisions, sc[i].Sequence, sc[i].TemplateID,
 			sc[i].Status, sc[i].RelativeID, sc[i].Created, sc[i].Revised)
@@ -1105,6 +1585,13 @@ func (r *restoreHandler) dmzSection() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1127,6 +1614,7 @@ func (r *restoreHandler) dmzSectionMeta() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeySectionMeta(sm)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1137,25 +1625,27 @@ func (r *restoreHandler) dmzSectionMeta() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_section_meta"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_section_meta WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
-
+	err = r.clear("dmz_section_meta")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_section_meta", "c_sectionid, c_orgid, c_userid, c_docid, c_rawbody, c_config, c_external, c_created, c_revised")
+
 	for i := range sm {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_section_meta
-            (c_sectionid, c_orgid, c_userid, c_docid, c_rawbody,
-            c_config, c_external, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			sm[i].SectionID, r.remapOrg(sm[i].OrgID), r.remapUser(sm[i].UserID), sm[i].DocumentID,
+		apply, e := r.merge("dmz_section_meta", "c_sectionid", "c_revised", sm[i].SectionID, sm[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, sm[i].SectionID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(sm[i].SectionID, r.remapOrg(sm[i].OrgID), r.remapUser(sm[i].UserID), sm[i].DocumentID,
 			sm[i].RawBody, sm[i].Config, sm[i].ExternalSource,
 			sm[i].Created, sm[i].Revised)
 
@@ -1166,6 +1656,13 @@ func (r *restoreHandler) dmzSectionMeta() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1188,6 +1685,7 @@ func (r *restoreHandler) dmzSectionRevision() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyRevisions(sr)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1198,24 +1696,27 @@ func (r *restoreHandler) dmzSectionRevision() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_section_revision"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_section_revision WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_section_revision")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_section_revision", "c_refid, c_orgid, c_docid, c_ownerid, c_sectionid, c_userid, c_contenttype, c_type, c_name, c_body, c_rawbody, c_config, c_created, c_revised")
+
 	for i := range sr {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_section_revision
-            (c_refid, c_orgid, c_docid, c_ownerid, c_sectionid, c_userid, c_contenttype,
-            c_type, c_name, c_body, c_rawbody, c_config, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			sr[i].RefID, r.remapOrg(sr[i].OrgID), sr[i].DocumentID, sr[i].OwnerID,
+		apply, e := r.merge("dmz_section_revision", "c_refid", "c_revised", sr[i].RefID, sr[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, sr[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(sr[i].RefID, r.remapOrg(sr[i].OrgID), sr[i].DocumentID, sr[i].OwnerID,
 			sr[i].SectionID, r.remapUser(sr[i].UserID), sr[i].ContentType, sr[i].Type, sr[i].Name,
 			sr[i].Body, sr[i].RawBody, sr[i].Config, sr[i].Created, sr[i].Revised)
 
@@ -1226,6 +1727,13 @@ func (r *restoreHandler) dmzSectionRevision() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1248,6 +1756,7 @@ func (r *restoreHandler) dmzSectionTemplate() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyTemplates(st)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1258,11 +1767,7 @@ func (r *restoreHandler) dmzSectionTemplate() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_section_template"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_section_template WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_section_template")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -1270,6 +1775,16 @@ func (r *restoreHandler) dmzSectionTemplate() (err error) {
 	}
 
 	for i := range st {
+		apply, e := r.merge("dmz_section_template", "c_refid", "c_revised", st[i].RefID, st[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, st[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_section_template
             (c_refid, c_orgid, c_spaceid, c_userid, c_contenttype,
@@ -1310,6 +1825,7 @@ func (r *restoreHandler) dmzDoc() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyDocuments(doc)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1320,26 +1836,28 @@ func (r *restoreHandler) dmzDoc() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_doc"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_doc WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_doc")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_doc", "c_refid, c_orgid, c_spaceid, c_userid, c_job, c_location, c_name, c_desc, c_slug, c_tags, c_template, c_protection, c_approval, c_lifecycle, c_versioned, c_versionid, c_versionorder, c_seq, c_groupid, c_created, c_revised")
+
 	for i := range doc {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_doc
-            (c_refid, c_orgid, c_spaceid, c_userid, c_job, c_location,
-            c_name, c_desc, c_slug, c_tags, c_template, c_protection, c_approval,
-			c_lifecycle, c_versioned, c_versionid, c_versionorder, c_seq, c_groupid,
-			c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			doc[i].RefID, r.remapOrg(doc[i].OrgID), doc[i].SpaceID, r.remapUser(doc[i].UserID), doc[i].Job,
+		apply, e := r.merge("dmz_doc", "c_refid", "c_revised", doc[i].RefID, doc[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, doc[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+		r.Documents[doc[i].RefID] = true
+
+		err = batch.add(doc[i].RefID, r.remapOrg(doc[i].OrgID), doc[i].SpaceID, r.remapUser(doc[i].UserID), doc[i].Job,
 			doc[i].Location, doc[i].Name, doc[i].Excerpt, doc[i].Slug, doc[i].Tags,
 			doc[i].Template, doc[i].Protection, doc[i].Approval, doc[i].Lifecycle,
 			doc[i].Versioned, doc[i].VersionID, doc[i].VersionOrder, doc[i].Sequence, doc[i].GroupID,
@@ -1352,6 +1870,13 @@ func (r *restoreHandler) dmzDoc() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1368,21 +1893,13 @@ func (r *restoreHandler) dmzDoc() (err error) {
 func (r *restoreHandler) dmzDocVote() (err error) {
 	filename := "dmz_doc_vote.json"
 
-	type vote struct {
-		RefID      string    `json:"refId"`
-		OrgID      string    `json:"orgId"`
-		DocumentID string    `json:"documentId"`
-		VoterID    string    `json:"voterId"`
-		Vote       int       `json:"vote"`
-		Created    time.Time `json:"created"`
-		Revised    time.Time `json:"revised"`
-	}
 	v := []vote{}
 	err = r.fileJSON(filename, &v)
 	if err != nil {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyVotes(v)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1393,22 +1910,27 @@ func (r *restoreHandler) dmzDocVote() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_doc_vote"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_doc_vote WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_doc_vote")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_doc_vote", "c_refid, c_orgid, c_docid, c_voter, c_vote, c_created, c_revised")
+
 	for i := range v {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_doc_vote (c_refid, c_orgid, c_docid, c_voter, c_vote, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?)`),
-			v[i].RefID, r.remapOrg(v[i].OrgID), v[i].DocumentID, v[i].VoterID, v[i].Vote, v[i].Created, v[i].Revised)
+		apply, e := r.merge("dmz_doc_vote", "c_refid", "c_revised", v[i].RefID, v[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, v[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(v[i].RefID, r.remapOrg(v[i].OrgID), v[i].DocumentID, v[i].VoterID, v[i].Vote, v[i].Created, v[i].Revised)
 
 		if err != nil {
 			r.Context.Transaction.Rollback()
@@ -1417,6 +1939,13 @@ func (r *restoreHandler) dmzDocVote() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1439,6 +1968,7 @@ func (r *restoreHandler) dmzDocLink() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyLinks(lk)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1449,24 +1979,27 @@ func (r *restoreHandler) dmzDocLink() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_doc_link"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_doc_link WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_doc_link")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_doc_link", "c_refid, c_orgid, c_spaceid, c_userid, c_sourcedocid, c_sourcesectionid, c_targetdocid, c_targetid, c_externalid, c_type, c_orphan, c_created, c_revised")
+
 	for i := range lk {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_doc_link
-            (c_refid, c_orgid, c_spaceid, c_userid, c_sourcedocid, c_sourcesectionid,
-            c_targetdocid, c_targetid, c_externalid, c_type, c_orphan, c_created, c_revised)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			lk[i].RefID, r.remapOrg(lk[i].OrgID), lk[i].SpaceID, r.remapUser(lk[i].UserID),
+		apply, e := r.merge("dmz_doc_link", "c_refid", "c_revised", lk[i].RefID, lk[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, lk[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(lk[i].RefID, r.remapOrg(lk[i].OrgID), lk[i].SpaceID, r.remapUser(lk[i].UserID),
 			lk[i].SourceDocumentID, lk[i].SourceSectionID,
 			lk[i].TargetDocumentID, lk[i].TargetID, lk[i].ExternalID, lk[i].LinkType, lk[i].Orphan,
 			lk[i].Created, lk[i].Revised)
@@ -1478,6 +2011,13 @@ func (r *restoreHandler) dmzDocLink() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1500,6 +2040,7 @@ func (r *restoreHandler) dmzDocAttachment() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyAttachments(at)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1510,11 +2051,7 @@ func (r *restoreHandler) dmzDocAttachment() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_doc_attachment"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_doc_attachment WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_doc_attachment")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -1522,6 +2059,16 @@ func (r *restoreHandler) dmzDocAttachment() (err error) {
 	}
 
 	for i := range at {
+		apply, e := r.merge("dmz_doc_attachment", "c_refid", "c_revised", at[i].RefID, at[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, at[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_doc_attachment
             (c_refid, c_orgid, c_docid, c_sectionid, c_job, c_fileid,
@@ -1561,6 +2108,7 @@ func (r *restoreHandler) dmzDocComment() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyComments(cm)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1571,23 +2119,27 @@ func (r *restoreHandler) dmzDocComment() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_doc_comment"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_doc_comment WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_doc_comment")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_doc_comment", "c_refid, c_orgid, c_userid, c_docid, c_email, c_feedback, c_replyto, c_sectionid, c_created")
+
 	for i := range cm {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_doc_comment
-            (c_refid, c_orgid, c_userid, c_docid, c_email, c_feedback, c_replyto, c_sectionid, c_created)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			cm[i].RefID, r.remapOrg(cm[i].OrgID), r.remapUser(cm[i].UserID), cm[i].DocumentID,
+		apply, e := r.merge("dmz_doc_comment", "c_refid", "c_created", cm[i].RefID, cm[i].Created)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, cm[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
+		err = batch.add(cm[i].RefID, r.remapOrg(cm[i].OrgID), r.remapUser(cm[i].UserID), cm[i].DocumentID,
 			cm[i].Email, cm[i].Feedback, cm[i].ReplyTo, cm[i].SectionID, cm[i].Created)
 
 		if err != nil {
@@ -1597,6 +2149,13 @@ func (r *restoreHandler) dmzDocComment() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1632,6 +2191,9 @@ func (r *restoreHandler) dmzDocShare() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	for i := range sh {
+		sh[i].DocumentID = r.remapID(sh[i].DocumentID)
+	}
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1642,11 +2204,7 @@ func (r *restoreHandler) dmzDocShare() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_doc_share"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_doc_share WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_doc_share")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -1654,6 +2212,20 @@ func (r *restoreHandler) dmzDocShare() (err error) {
 	}
 
 	for i := range sh {
+		// Shares made by users that did not make it are dropped.
+		var found bool
+		found, err = r.exists("dmz_user", r.remapUser(sh[i].UserID))
+		if err != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(err, fmt.Sprintf("unable to check %s %s", filename, sh[i].UserID))
+			return
+		}
+		if !found {
+			r.Runtime.Log.Infof("Restore dropped share for missing user %s", sh[i].UserID)
+			r.DroppedRows["dmz_doc_share"]++
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_doc_share
             (c_orgid, c_userid, c_docid, c_email, c_message,
@@ -1700,9 +2272,13 @@ func (r *restoreHandler) dmzUser() (err error) {
 		return
 	}
 
+	// Tenant restores always match users by email address,
+	// global restores only do so when asked.
+	matchEmail := !r.Spec.GlobalBackup || r.Spec.MatchEmail
+
 	// Nuke all existing data.
-	if r.Spec.GlobalBackup {
-		_, err = r.Context.Transaction.Exec("TRUNCATE TABLE dmz_user")
+	if !matchEmail {
+		err = r.clear("dmz_user")
 		if err != nil {
 			r.Context.Transaction.Rollback()
 			err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -1710,26 +2286,58 @@ func (r *restoreHandler) dmzUser() (err error) {
 		}
 	}
 
+	// Email (lowercase) to local user ID for users already processed.
+	seen := make(map[string]string)
+
 	for i := range u {
-		// For tenant backups we first check to see if user exists.
 		insert := true
-		if !r.Spec.GlobalBackup {
-			row := r.Runtime.Db.QueryRow(r.Runtime.Db.Rebind("SELECT COALESCE(c_refid, '') AS userid FROM dmz_user WHERE c_email=?"), u[i].Email)
-			userID := ""
-			err = row.Scan(&userID)
-			if err == sql.ErrNoRows {
-				err = nil
-				insert = true
+		if matchEmail {
+			email := strings.ToLower(strings.TrimSpace(u[i].Email))
+
+			// Same person may appear more than once in backup with differing email case.
+			if userID, ok := seen[email]; ok {
+				r.Runtime.Log.Infof("Restore found duplicate email %s, mapping user %s to %s", email, u[i].RefID, userID)
+				r.MapUserID[u[i].RefID] = userID
+				continue
 			}
+
+			var userID string
+			userID, err = r.matchUserEmail(email)
 			if err != nil {
 				r.Context.Transaction.Rollback()
 				err = errors.Wrap(err, fmt.Sprintf("unable to check email %s", u[i].Email))
 				return
 			}
-			// Existing userID from database overrides all incoming userID values by using remapUser().
+
 			if len(userID) > 0 {
+				// Existing userID from database overrides all incoming userID values by using remapUser().
 				r.MapUserID[u[i].RefID] = userID
 				insert = false
+			} else {
+				// New user, but incoming ID may already belong to somebody else.
+				// Merge restores treat matching IDs as the same user.
+				var taken int
+				err = r.Context.Transaction.Get(&taken, r.Runtime.Db.Rebind("SELECT COUNT(*) FROM dmz_user WHERE c_refid=?"), u[i].RefID)
+				if err != nil {
+					r.Context.Transaction.Rollback()
+					err = errors.Wrap(err, fmt.Sprintf("unable to check user %s", u[i].RefID))
+					return
+				}
+				if taken > 0 && !r.Spec.Merge {
+					r.MapUserID[u[i].RefID] = uniqueid.Generate()
+					r.Runtime.Log.Infof("Restore found user ID conflict for %s, using %s", u[i].RefID, r.remapUser(u[i].RefID))
+				}
+			}
+
+			seen[email] = r.remapUser(u[i].RefID)
+		}
+
+		if insert {
+			insert, err = r.merge("dmz_user", "c_refid", "c_revised", r.remapUser(u[i].RefID), u[i].Revised)
+			if err != nil {
+				r.Context.Transaction.Rollback()
+				err = errors.Wrap(err, fmt.Sprintf("unable to merge %s %s", filename, u[i].RefID))
+				return
 			}
 		}
 
@@ -1763,6 +2371,26 @@ func (r *restoreHandler) dmzUser() (err error) {
 	return nil
 }
 
+// Returns ID of existing user with matching email (case-insensitive).
+// Where more than one local user matches, the oldest user wins.
+func (r *restoreHandler) matchUserEmail(email string) (userID string, err error) {
+	ids := []string{}
+	err = r.Context.Transaction.Select(&ids, r.Runtime.Db.Rebind(`
+        SELECT c_refid FROM dmz_user WHERE LOWER(c_email)=? ORDER BY c_created`), email)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil || len(ids) == 0 {
+		return
+	}
+
+	if len(ids) > 1 {
+		r.Runtime.Log.Infof("Restore found %d users with email %s, using %s", len(ids), email, ids[0])
+	}
+
+	return ids[0], nil
+}
+
 // Account.
 func (r *restoreHandler) dmzUserAccount() (err error) {
 	filename := "dmz_user_account.json"
@@ -1783,11 +2411,7 @@ func (r *restoreHandler) dmzUserAccount() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_user_account"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_user_account WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_user_account")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -1795,6 +2419,16 @@ func (r *restoreHandler) dmzUserAccount() (err error) {
 	}
 
 	for i := range ac {
+		apply, e := r.merge("dmz_user_account", "c_refid", "c_revised", ac[i].RefID, ac[i].Revised)
+		if e != nil {
+			r.Context.Transaction.Rollback()
+			err = errors.Wrap(e, fmt.Sprintf("unable to merge %s %s", filename, ac[i].RefID))
+			return
+		}
+		if !apply {
+			continue
+		}
+
 		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
             INSERT INTO dmz_user_account
             (c_refid, c_orgid, c_userid, c_admin, c_editor, c_users,
@@ -1832,6 +2466,7 @@ func (r *restoreHandler) dmzUserActivity() (err error) {
 		err = errors.Wrap(err, fmt.Sprintf("failed to load %s", filename))
 		return
 	}
+	r.rekeyActivity(ac)
 
 	r.Runtime.Log.Info(fmt.Sprintf("Extracted %s", filename))
 
@@ -1842,24 +2477,17 @@ func (r *restoreHandler) dmzUserActivity() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_user_activity"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_user_activity WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_user_activity")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
 		return
 	}
 
+	batch := r.newBatch("dmz_user_activity", "c_orgid, c_userid, c_spaceid, c_docid, c_sectionid, c_sourcetype, c_activitytype, c_metadata, c_created")
+
 	for i := range ac {
-		_, err = r.Context.Transaction.Exec(r.Runtime.Db.Rebind(`
-            INSERT INTO dmz_user_activity
-            (c_orgid, c_userid, c_spaceid, c_docid, c_sectionid, c_sourcetype,
-            c_activitytype, c_metadata, c_created)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
-			r.remapOrg(ac[i].OrgID), r.remapUser(ac[i].UserID), ac[i].SpaceID, ac[i].DocumentID,
+		err = batch.add(r.remapOrg(ac[i].OrgID), r.remapUser(ac[i].UserID), ac[i].SpaceID, ac[i].DocumentID,
 			ac[i].SectionID, ac[i].SourceType, ac[i].ActivityType,
 			ac[i].Metadata, ac[i].Created)
 
@@ -1870,6 +2498,13 @@ func (r *restoreHandler) dmzUserActivity() (err error) {
 		}
 	}
 
+	err = batch.flush()
+	if err != nil {
+		r.Context.Transaction.Rollback()
+		err = errors.Wrap(err, fmt.Sprintf("unable to insert %s", filename))
+		return
+	}
+
 	err = r.Context.Transaction.Commit()
 	if err != nil {
 		r.Context.Transaction.Rollback()
@@ -1908,11 +2543,7 @@ func (r *restoreHandler) dmzUserConfig() (err error) {
 	}
 
 	// Nuke all existing data.
-	nuke := "TRUNCATE TABLE dmz_user_config"
-	if !r.Spec.GlobalBackup {
-		nuke = fmt.Sprintf("DELETE FROM dmz_user_config WHERE c_orgid='%s'", r.Spec.Org.RefID)
-	}
-	_, err = r.Context.Transaction.Exec(nuke)
+	err = r.clear("dmz_user_config")
 	if err != nil {
 		r.Context.Transaction.Rollback()
 		err = errors.Wrap(err, fmt.Sprintf("unable to truncate table %s", filename))
@@ -12,7 +12,17 @@
 package backup
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
 	"testing"
+
+	"github.com/documize/community/core/env"
+	m "github.com/documize/community/model/backup"
+	"github.com/documize/community/model/category"
+	"github.com/documize/community/model/permission"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
 // go test github.com/documize/community/domain/backup -run TestRemapORg
@@ -57,3 +67,221 @@ func TestRemapUser(t *testing.T) {
 		t.Errorf("expected jkl got %s", n)
 	}
 }
+
+func TestCheckDiskSpace(t *testing.T) {
+	if err := checkDiskSpace(100, 200); err != nil {
+		t.Errorf("expected no error got %s", err)
+	}
+
+	if err := checkDiskSpace(200, 200); err != nil {
+		t.Errorf("expected no error got %s", err)
+	}
+
+	err := checkDiskSpace(300, 200)
+	if err == nil {
+		t.Error("expected insufficient disk space error")
+		return
+	}
+	if err.Error() != "insufficient disk space: need 300 bytes, have 200 bytes" {
+		t.Errorf("unexpected error message %s", err)
+	}
+}
+
+func TestCountDiscrepancy(t *testing.T) {
+	if d := countDiscrepancy("dmz_doc", 10, 10, false); d != "" {
+		t.Errorf("expected no discrepancy got %s", d)
+	}
+	if d := countDiscrepancy("dmz_doc", 10, 9, false); d != "dmz_doc expected 10 rows, found 9" {
+		t.Errorf("unexpected discrepancy %s", d)
+	}
+	if d := countDiscrepancy("dmz_doc", 10, 11, false); d == "" {
+		t.Error("expected discrepancy for extra rows")
+	}
+
+	// Merge keeps existing rows.
+	if d := countDiscrepancy("dmz_doc", 10, 15, true); d != "" {
+		t.Errorf("expected no discrepancy got %s", d)
+	}
+	if d := countDiscrepancy("dmz_doc", 10, 9, true); d == "" {
+		t.Error("expected discrepancy for missing rows")
+	}
+}
+
+// Merge restores verify every table they wrote, expecting at least
+// as many rows as the backup held.
+func TestVerifyCountsMerge(t *testing.T) {
+	db := &stubDB{rows: map[string]stubRows{
+		"FROM dmz_doc WHERE":   {cols: []string{"count"}, vals: []driver.Value{int64(12)}},
+		"FROM dmz_space WHERE": {cols: []string{"count"}, vals: []driver.Value{int64(4)}},
+	}}
+	r := restoreHandler{Runtime: &env.Runtime{Log: nopLogger{}, Db: sqlx.NewDb(sql.OpenDB(db), "postgres")}}
+	r.Spec.Merge = true
+	r.Spec.Org.RefID = "o1"
+	r.Spec.Manifest.Counts = map[string]int{"dmz_doc": 10, "dmz_space": 5, "dmz_config": 3}
+	r.Restored = []string{"dmz_doc", "dmz_space"}
+
+	d, err := r.verifyCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d) != 1 || d[0] != "dmz_space expected 5 rows, found 4" {
+		t.Errorf("unexpected discrepancies %v", d)
+	}
+	if len(db.queries) != 2 {
+		t.Errorf("expected only restored tables to be counted got %v", db.queries)
+	}
+}
+
+func TestResumeIndex(t *testing.T) {
+	steps := []restoreStep{{table: "dmz_org"}, {table: "dmz_user"}, {table: "dmz_doc"}}
+
+	if i := resumeIndex(steps, "dmz_user"); i != 2 {
+		t.Errorf("expected 2 got %d", i)
+	}
+	if i := resumeIndex(steps, "dmz_doc"); i != 3 {
+		t.Errorf("expected 3 got %d", i)
+	}
+	if i := resumeIndex(steps, "dmz_unknown"); i != 0 {
+		t.Errorf("expected 0 got %d", i)
+	}
+}
+
+func TestResolveSlug(t *testing.T) {
+	r := restoreHandler{Renamed: make(map[string]string)}
+	taken := map[string]string{"engineering": "s1", "engineering-2": "s9"}
+
+	// Same row is not a conflict.
+	if c := r.resolveSlug("space", "s1", "Engineering", "", taken); c != "" {
+		t.Errorf("expected no conflict got %s", c)
+	}
+
+	// Different row with same slug is reported.
+	if c := r.resolveSlug("space", "s2", "engineering", "", taken); c == "" {
+		t.Error("expected conflict")
+	}
+	if len(r.Renamed) != 0 {
+		t.Error("expected no renames without RenameConflicts")
+	}
+
+	// Rename picks first free suffix.
+	r.Spec.RenameConflicts = true
+	r.resolveSlug("space", "s3", "Engineering", "", taken)
+	if n := r.rename("s3", "Engineering"); n != "Engineering (3)" {
+		t.Errorf("expected Engineering (3) got %s", n)
+	}
+	if n := r.rename("s4", "Sales"); n != "Sales" {
+		t.Errorf("expected Sales got %s", n)
+	}
+}
+
+// Categories and members follow regenerated IDs and are dropped
+// when what they belong to did not make it.
+func TestCategoryOrphans(t *testing.T) {
+	r := restoreHandler{
+		Runtime:     &env.Runtime{Log: nopLogger{}},
+		DroppedRows: make(map[string]int),
+		MapID:       map[string]string{"s1": "s1n", "c1": "c1n", "c2": "c2n", "d1": "d1n"},
+	}
+
+	ct := []category.Category{{SpaceID: "s1", Name: "Policies"}, {SpaceID: "s2", Name: "Orphan"}}
+	ct[0].RefID = "c1"
+	ct[1].RefID = "c2"
+	cm := []category.Member{
+		{CategoryID: "c1", SpaceID: "s1", DocumentID: "d1"},
+		{CategoryID: "c2", SpaceID: "s2", DocumentID: "d1"},
+		{CategoryID: "c1", SpaceID: "s1", DocumentID: "d2"},
+	}
+	cm[0].RefID = "m1"
+	cm[1].RefID = "m2"
+	cm[2].RefID = "m3"
+
+	r.rekeyCategories(ct)
+	r.rekeyCategoryMembers(cm)
+
+	// Only remapped space, category and document exist after restore.
+	db := map[string]bool{"dmz_space/s1n": true, "dmz_category/c1n": true, "dmz_doc/d1n": true}
+	exists := func(table, refID string) (bool, error) { return db[table+"/"+refID], nil }
+
+	ct, err := r.categoriesWithSpace(ct, exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ct) != 1 || ct[0].RefID != "c1n" || ct[0].SpaceID != "s1n" {
+		t.Errorf("unexpected categories %+v", ct)
+	}
+
+	cm, err = r.membersWithParents(cm, exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm) != 1 || cm[0].RefID != "m1" || cm[0].CategoryID != "c1n" || cm[0].DocumentID != "d1n" || cm[0].SpaceID != "s1n" {
+		t.Errorf("unexpected category members %+v", cm)
+	}
+
+	if r.DroppedRows["dmz_category"] != 1 || r.DroppedRows["dmz_category_member"] != 2 {
+		t.Errorf("unexpected dropped rows %v", r.DroppedRows)
+	}
+
+	// Lookup failure stops restore.
+	fail := func(string, string) (bool, error) { return false, errors.New("boom") }
+	if _, err := r.categoriesWithSpace(ct, fail); err == nil {
+		t.Error("expected category lookup error")
+	}
+	if _, err := r.membersWithParents(cm, fail); err == nil {
+		t.Error("expected member lookup error")
+	}
+}
+
+// Merge restore of space backup must bring back its permissions.
+func TestMergePermissions(t *testing.T) {
+	r := restoreHandler{MapUserID: map[string]string{"u9": "u2"}}
+	refs := map[string]bool{"s1": true, "d1": true}
+
+	pm := []permission.Permission{
+		{WhoID: "u1", Action: permission.SpaceView, RefID: "s1"},
+		{WhoID: "u1", Action: permission.SpaceManage, RefID: "s1"},
+		{WhoID: "u9", Action: permission.SpaceView, RefID: "s1"},
+		{WhoID: "u2", Action: permission.SpaceView, RefID: "s1"},
+		{WhoID: "u1", Action: permission.DocumentApprove, RefID: "d1"},
+		{WhoID: "u1", Action: permission.SpaceView, RefID: "s1"},
+		{WhoID: "u1", Action: permission.SpaceView, RefID: "s9"},
+	}
+
+	// Existing grant is not duplicated.
+	granted := func(whoID string, action permission.Action, refID string) (bool, error) {
+		return whoID == "u1" && action == permission.SpaceManage && refID == "s1", nil
+	}
+
+	keep, err := r.mergePermissions(pm, refs, granted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, p := range keep {
+		got = append(got, r.remapUser(p.WhoID)+"/"+string(p.Action)+"/"+p.RefID)
+	}
+	want := []string{"u1/view/s1", "u2/view/s1", "u1/doc-approve/d1"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v got %v", want, got)
+	}
+
+	if _, err := r.mergePermissions(pm, refs, func(string, permission.Action, string) (bool, error) {
+		return false, errors.New("boom")
+	}); err == nil {
+		t.Error("expected lookup error")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	mf := m.Manifest{StoreType: env.StoreTypeMySQL, SchemaVersion: 30}
+
+	if v := schemaVersion(mf, env.StoreTypeMySQL); v != 30 {
+		t.Errorf("expected 30 got %d", v)
+	}
+
+	// Versions differ between providers.
+	if v := schemaVersion(mf, env.StoreTypePostgreSQL); v != 0 {
+		t.Errorf("expected 0 got %d", v)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Scheduled backups are system backups taken in the background
+// according to a cron schedule (-backupschedule). They are written to
+// the "scheduled" folder inside the backup temp folder, where only the
+// newest -backupretain archives are kept.
+//
+// There is no coordination between instances, so when several instances
+// share one database the schedule should be set on one of them only.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/store"
+	m "github.com/documize/community/model/backup"
+)
+
+const (
+	scheduledDir           = "scheduled"
+	scheduledRetainDefault = 7
+)
+
+// schedule holds allowed values of each cron field as bit sets.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Standard cron runs when either day field matches
+	// if both are restricted.
+	domAny, dowAny bool
+}
+
+var scheduleMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule reads five field cron expression
+// (minute hour day-of-month month day-of-week).
+// Fields accept *, values, ranges, lists and steps (e.g. */15, 1-5, 0,30).
+func parseSchedule(spec string) (s schedule, err error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := scheduleMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return s, fmt.Errorf("schedule %q must have 5 fields", spec)
+	}
+
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return s, errors.Wrap(err, "minute")
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return s, errors.Wrap(err, "hour")
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return s, errors.Wrap(err, "day of month")
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return s, errors.Wrap(err, "month")
+	}
+	// Sunday is both 0 and 7.
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return s, errors.Wrap(err, "day of week")
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+
+	return s, nil
+}
+
+// parseField returns bit set of values allowed by single cron field.
+func parseField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 to end in steps of 15.
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+// dayMatches applies standard cron rule for day fields.
+func (s schedule) dayMatches(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// next returns first time after given time that matches schedule.
+// Zero time is returned if nothing matches within five years
+// (e.g. 30th of February).
+func (s schedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// scheduleRetain reads number of scheduled backups to keep,
+// using default for missing or invalid value.
+func scheduleRetain(f env.Flags) int {
+	if n, err := strconv.Atoi(f.BackupRetain); err == nil && n > 0 {
+		return n
+	}
+
+	return scheduledRetainDefault
+}
+
+// Schedule starts background worker that takes system backups
+// according to -backupschedule. Does nothing if no schedule is set.
+func Schedule(rt *env.Runtime, s *store.Store) {
+	if len(rt.Flags.BackupSchedule) == 0 {
+		return
+	}
+
+	sched, err := parseSchedule(rt.Flags.BackupSchedule)
+	if err != nil {
+		rt.Log.Error("Scheduled backups disabled", err)
+		return
+	}
+
+	retain := scheduleRetain(rt.Flags)
+	dir := filepath.Join(rt.Flags.BackupTempDir, scheduledDir)
+
+	rt.Log.Info(fmt.Sprintf("Scheduled backups enabled (%s), keeping %d in %s", rt.Flags.BackupSchedule, retain, dir))
+
+	go func() {
+		for {
+			at := sched.next(time.Now())
+			if at.IsZero() {
+				rt.Log.Info("Scheduled backups stopped, schedule has no future run")
+				return
+			}
+
+			wait := time.NewTimer(time.Until(at))
+			select {
+			case <-wait.C:
+			case <-rt.ShutdownDone():
+				wait.Stop()
+				return
+			}
+
+			runScheduledBackup(rt, s, dir, retain)
+		}
+	}()
+}
+
+// runScheduledBackup takes one system backup and prunes old ones.
+func runScheduledBackup(rt *env.Runtime, s *store.Store, dir string, retain int) {
+	method := "system.backup.scheduled"
+
+	// Database may still be awaiting setup, or site taken offline.
+	if rt.Flags.SiteMode != env.SiteModeNormal {
+		rt.Log.Info("Scheduled backup skipped, site is not in normal mode")
+		return
+	}
+
+	start := time.Now()
+	ctx := domain.RequestContext{Administrator: true, GlobalAdmin: true}
+	bh := backerHandler{Runtime: rt, Store: s, Context: ctx, Spec: m.ExportSpec{OrgID: "*", Retain: true}, Cancel: rt.ShutdownDone()}
+
+	filename, err := bh.GenerateBackup()
+	if err == nil {
+		filename, err = keepScheduled(filename, dir)
+	}
+	if err != nil {
+		rt.Log.Error(method, err)
+		rt.Log.Info(fmt.Sprintf("Scheduled backup failed after %s", time.Since(start).Round(time.Second)))
+		return
+	}
+
+	var size int64
+	if info, e := os.Stat(filename); e == nil {
+		size = info.Size()
+	}
+	rt.Log.Info(fmt.Sprintf("Scheduled backup completed in %s, size %d, file %s", time.Since(start).Round(time.Second), size, filename))
+
+	removed, err := pruneBackups(dir, retain)
+	if err != nil {
+		rt.Log.Error(method, err)
+	}
+	for _, f := range removed {
+		rt.Log.Info("Scheduled backup removed " + f)
+	}
+}
+
+// keepScheduled moves finished backup into scheduled folder.
+func keepScheduled(filename, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		os.Remove(filename)
+		return "", errors.Wrap(err, "cannot create scheduled backup folder")
+	}
+
+	target := filepath.Join(dir, filepath.Base(filename))
+	if err := os.Rename(filename, target); err != nil {
+		os.Remove(filename)
+		return "", errors.Wrap(err, "cannot move scheduled backup")
+	}
+
+	return target, nil
+}
+
+// pruneBackups removes all but newest backups in folder
+// and returns names of files removed.
+func pruneBackups(dir string, keep int) (removed []string, err error) {
+	files, err := filepath.Glob(filepath.Join(dir, backupFilename("*")))
+	if err != nil {
+		return
+	}
+	if len(files) <= keep {
+		return
+	}
+
+	type backupFile struct {
+		name    string
+		modTime time.Time
+	}
+	var found []backupFile
+	for _, f := range files {
+		info, e := os.Stat(f)
+		if e != nil {
+			continue
+		}
+		found = append(found, backupFile{name: f, modTime: info.ModTime()})
+	}
+
+	// Newest first, name breaks ties so order is stable.
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].modTime.Equal(found[j].modTime) {
+			return found[i].modTime.After(found[j].modTime)
+		}
+		return found[i].name > found[j].name
+	})
+
+	for i := keep; i < len(found); i++ {
+		if e := os.Remove(found[i].name); e != nil {
+			err = errors.Wrap(e, "cannot remove old scheduled backup")
+			continue
+		}
+		removed = append(removed, found[i].name)
+	}
+
+	return
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+)
+
+func TestParseSchedule(t *testing.T) {
+	valid := []string{"0 2 * * *", "*/15 * * * *", "0 9-17 * * 1-5", "0,30 * 1,15 * *", "5/20 * * * *", "0 0 * * 7", "@daily"}
+	for _, spec := range valid {
+		if _, err := parseSchedule(spec); err != nil {
+			t.Errorf("%s: unexpected error %s", spec, err)
+		}
+	}
+
+	invalid := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"}
+	for _, spec := range invalid {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		spec, after, next string
+	}{
+		{"0 2 * * *", "2026-03-10 01:30", "2026-03-10 02:00"},
+		{"0 2 * * *", "2026-03-10 02:00", "2026-03-11 02:00"},
+		{"*/15 * * * *", "2026-03-10 10:07", "2026-03-10 10:15"},
+		{"5/20 * * * *", "2026-03-10 10:26", "2026-03-10 10:45"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"30 6 * * 1-5", "2026-03-13 07:00", "2026-03-16 06:30"}, // Friday to Monday
+		{"0 0 * * 7", "2026-03-10 00:00", "2026-03-15 00:00"},    // Sunday as 7
+		{"0 0 13 * 5", "2026-03-10 00:00", "2026-03-13 00:00"},   // either day field
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},   // leap day
+	}
+
+	for _, tc := range tests {
+		s, err := parseSchedule(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := s.next(at(tc.after)); !n.Equal(at(tc.next)) {
+			t.Errorf("%s after %s: expected %s got %s", tc.spec, tc.after, tc.next, n.Format("2006-01-02 15:04"))
+		}
+	}
+
+	// Impossible date never runs.
+	s, _ := parseSchedule("0 0 30 2 *")
+	if n := s.next(at("2026-01-01 00:00")); !n.IsZero() {
+		t.Errorf("expected no run got %s", n)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dmz-prune-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	names := []string{"a", "b", "c", "d"}
+	for i, n := range names {
+		f := filepath.Join(dir, backupFilename(n))
+		if err := ioutil.WriteFile(f, []byte(n), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// a is oldest, d newest
+		mt := now.Add(time.Duration(i-len(names)) * time.Hour)
+		os.Chtimes(f, mt, mt)
+	}
+	// Other files are left alone.
+	other := filepath.Join(dir, "notes.txt")
+	ioutil.WriteFile(other, []byte("x"), 0600)
+
+	removed, err := pruneBackups(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || filepath.Base(removed[0]) != backupFilename("b") || filepath.Base(removed[1]) != backupFilename("a") {
+		t.Errorf("unexpected files removed %v", removed)
+	}
+	for _, n := range []string{"c", "d"} {
+		if _, err := os.Stat(filepath.Join(dir, backupFilename(n))); err != nil {
+			t.Errorf("expected %s kept", n)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("expected unrelated file kept")
+	}
+
+	// Nothing to do within retention.
+	if removed, _ := pruneBackups(dir, 5); len(removed) != 0 {
+		t.Errorf("expected nothing removed got %v", removed)
+	}
+}
+
+func TestScheduleRetain(t *testing.T) {
+	if n := scheduleRetain(env.Flags{}); n != scheduledRetainDefault {
+		t.Errorf("expected default got %d", n)
+	}
+	if n := scheduleRetain(env.Flags{BackupRetain: "3"}); n != 3 {
+		t.Errorf("expected 3 got %d", n)
+	}
+	if n := scheduleRetain(env.Flags{BackupRetain: "-1"}); n != scheduledRetainDefault {
+		t.Errorf("expected default got %d", n)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Large archives can be split into numbered parts for transfer
+// pipelines that cap file size. Parts are plain byte ranges of the
+// archive, so they must be joined in order before restore.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	m "github.com/documize/community/model/backup"
+	"github.com/pkg/errors"
+)
+
+// splitArchive breaks archive into parts of at most size bytes,
+// writes part manifest next to them and removes the original archive.
+// Returns filename of part manifest.
+func splitArchive(filename, id string, size int64) (index string, err error) {
+	if size <= 0 {
+		return "", errors.New("split size must be greater than zero")
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	base := strings.TrimSuffix(filename, ".zip")
+	pm := m.PartManifest{ID: id}
+	whole := sha256.New()
+
+	var written []string
+	defer func() {
+		// Incomplete part set is of no use to anybody.
+		if err != nil {
+			for _, f := range written {
+				os.Remove(f)
+			}
+		}
+	}()
+
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s.part%03d.zip", base, n)
+
+		dst, e := os.Create(name)
+		if e != nil {
+			return "", e
+		}
+		written = append(written, name)
+
+		h := sha256.New()
+		c, e := io.Copy(io.MultiWriter(dst, h, whole), io.LimitReader(src, size))
+		dst.Close()
+		if e != nil {
+			return "", e
+		}
+
+		if c == 0 {
+			os.Remove(name)
+			written = written[:len(written)-1]
+			break
+		}
+
+		pm.Parts = append(pm.Parts, m.Part{Filename: filepath.Base(name), Size: c, Checksum: hex.EncodeToString(h.Sum(nil))})
+		pm.Size += c
+
+		if c < size {
+			break
+		}
+	}
+
+	pm.Checksum = hex.EncodeToString(whole.Sum(nil))
+
+	content, err := toJSON(pm)
+	if err != nil {
+		return
+	}
+
+	index = base + ".parts.json"
+	err = ioutil.WriteFile(index, []byte(content), 0600)
+	if err != nil {
+		return
+	}
+	written = append(written, index)
+
+	src.Close()
+	err = os.Remove(filename)
+
+	return
+}
+
+// joinParts reassembles archive from parts keyed by filename,
+// verifying each part and the complete archive against part manifest.
+func joinParts(pm m.PartManifest, parts map[string][]byte) (b []byte, err error) {
+	if len(pm.Parts) == 0 {
+		return nil, errors.New("part manifest lists no parts")
+	}
+
+	// Part manifest comes from caller so archive size is taken
+	// from the parts themselves before anything is allocated.
+	var size int64
+	for i, p := range pm.Parts {
+		data, ok := parts[p.Filename]
+		if !ok {
+			return nil, fmt.Errorf("missing part %d %s", i+1, p.Filename)
+		}
+		if int64(len(data)) != p.Size || archiveChecksum(data) != p.Checksum {
+			return nil, fmt.Errorf("part %d %s is corrupt", i+1, p.Filename)
+		}
+		size += int64(len(data))
+	}
+	if pm.Size <= 0 || pm.Size != size {
+		return nil, fmt.Errorf("part manifest size %d does not match parts size %d", pm.Size, size)
+	}
+
+	b = make([]byte, 0, size)
+	for _, p := range pm.Parts {
+		b = append(b, parts[p.Filename]...)
+	}
+
+	if archiveChecksum(b) != pm.Checksum {
+		return nil, errors.New("reassembled archive checksum mismatch")
+	}
+
+	return b, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	m "github.com/documize/community/model/backup"
+)
+
+// Archive split into three parts must restore byte for byte.
+func TestSplitRoundTrip(t *testing.T) {
+	filename := writeTestZip(t, []backupItem{
+		{Filename: "manifest.json", Content: `{"id":"abc"}`},
+		{Filename: "dmz_doc.json", Content: "[" + strings.Repeat(`{"name":"document"},`, 50) + `{"name":"last"}]`},
+	})
+	original, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(original)/3 + 1)
+	index, err := splitArchive(filename, "abc", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error("expected original archive to be removed")
+	}
+
+	content, err := ioutil.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := m.PartManifest{}
+	if err := json.Unmarshal(content, &pm); err != nil {
+		t.Fatal(err)
+	}
+	if len(pm.Parts) != 3 {
+		t.Fatalf("expected 3 parts got %d", len(pm.Parts))
+	}
+	if pm.Parts[0].Filename != "backup.part001.zip" || pm.Parts[2].Filename != "backup.part003.zip" {
+		t.Errorf("unexpected part names %s %s", pm.Parts[0].Filename, pm.Parts[2].Filename)
+	}
+
+	parts := make(map[string][]byte)
+	for _, p := range pm.Parts {
+		if p.Size > size {
+			t.Errorf("part %s exceeds split size", p.Filename)
+		}
+		parts[p.Filename], err = ioutil.ReadFile(filepath.Join(filepath.Dir(index), p.Filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	joined, err := joinParts(pm, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(joined, original) {
+		t.Fatal("reassembled archive differs from original")
+	}
+	if _, err := zip.NewReader(bytes.NewReader(joined), int64(len(joined))); err != nil {
+		t.Errorf("reassembled archive unreadable %s", err)
+	}
+
+	// Manifest size must match parts.
+	for _, n := range []int64{-1, 0, pm.Size - 1, 1 << 62} {
+		bad := pm
+		bad.Size = n
+		if _, err := joinParts(bad, parts); err == nil {
+			t.Errorf("expected size mismatch error for %d", n)
+		}
+	}
+
+	// Corrupt or missing parts are rejected.
+	parts[pm.Parts[1].Filename][0] ^= 0xff
+	if _, err := joinParts(pm, parts); err == nil {
+		t.Error("expected corrupt part error")
+	}
+	delete(parts, pm.Parts[1].Filename)
+	if _, err := joinParts(pm, parts); err == nil {
+		t.Error("expected missing part error")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"fmt"
+
+	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/store"
+	m "github.com/documize/community/model/backup"
+	"github.com/pkg/errors"
+)
+
+// Store provides data access to backup row counts.
+type Store struct {
+	store.Context
+	store.BackupStorer
+}
+
+// Table and optional WHERE clause that backup uses to select rows.
+type tableScope struct {
+	table string
+	where string
+	args  []interface{}
+}
+
+// CountRows returns number of rows per table that a backup
+// of given scope would export, without reading the rows.
+// Used to size backups and report progress.
+func (s Store) CountRows(ctx domain.RequestContext, spec m.ExportSpec) (counts map[string]int, err error) {
+	counts = make(map[string]int)
+
+	// Scope IDs are written into queries.
+	err = checkScopeIDs(spec)
+	if err != nil {
+		return
+	}
+
+	for _, ts := range countScopes(spec) {
+		if spec.IsExcluded(ts.table) {
+			continue
+		}
+
+		var n int
+		err = s.Runtime.Db.Get(&n, s.Bind(fmt.Sprintf("SELECT COUNT(*) FROM %s%s", ts.table, ts.where)), ts.args...)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("unable to count %s", ts.table))
+			return
+		}
+
+		counts[ts.table] = n
+	}
+
+	return
+}
+
+// countScopes mirrors row selection used when producing backup.
+func countScopes(spec m.ExportSpec) (scopes []tableScope) {
+	b := backerHandler{Spec: spec}
+	system := spec.SystemBackup()
+
+	org := func(table string) tableScope {
+		if system {
+			return tableScope{table: table}
+		}
+		return tableScope{table: table, where: " WHERE c_orgid=?", args: []interface{}{spec.OrgID}}
+	}
+	doc := func(table, column string) tableScope {
+		ts := org(table)
+		ts.where = b.docScope(ts.where, column)
+		return ts
+	}
+	space := func(table, column string) tableScope {
+		ts := org(table)
+		ts.where = b.spaceScope(ts.where, column)
+		return ts
+	}
+
+	permission := org("dmz_permission")
+	permission.where = b.permissionScope(permission.where)
+	action := org("dmz_action")
+	action.where = b.spaceDocScope(action.where, "c_docid")
+
+	if system {
+		scopes = append(scopes,
+			tableScope{table: "dmz_org"},
+			tableScope{table: "dmz_config"},
+			tableScope{table: "dmz_user"})
+	} else {
+		scopes = append(scopes,
+			tableScope{table: "dmz_org", where: " WHERE c_refid=?", args: []interface{}{spec.OrgID}},
+			tableScope{table: "dmz_user", where: b.memberScope(" WHERE c_refid IN (SELECT c_userid FROM dmz_user_account WHERE c_orgid=?)", "c_refid"), args: []interface{}{spec.OrgID}})
+	}
+
+	account := org("dmz_user_account")
+	account.where = b.memberScope(account.where, "c_userid")
+	group := org("dmz_group")
+	group.where = b.groupScope(group.where, "c_refid")
+	member := org("dmz_group_member")
+	member.where = b.groupScope(member.where, "c_groupid")
+	label := org("dmz_space_label")
+	label.where = b.labelScope(label.where)
+
+	scopes = append(scopes,
+		org("dmz_user_config"),
+		account,
+		group,
+		member,
+		space("dmz_user_activity", "c_spaceid"),
+		space("dmz_pin", "c_spaceid"),
+		label,
+		space("dmz_space", "c_refid"),
+		permission,
+		space("dmz_category", "c_spaceid"),
+		space("dmz_category_member", "c_spaceid"),
+		doc("dmz_section", "c_docid"),
+		doc("dmz_section_meta", "c_docid"),
+		doc("dmz_section_revision", "c_docid"),
+		space("dmz_section_template", "c_spaceid"),
+		doc("dmz_doc", "c_refid"),
+		doc("dmz_doc_vote", "c_docid"),
+		doc("dmz_doc_link", "c_sourcedocid"),
+		doc("dmz_doc_comment", "c_docid"),
+		doc("dmz_doc_share", "c_docid"),
+		doc("dmz_doc_attachment", "c_docid"),
+		action)
+
+	if spec.IncludeAudit {
+		ts := org("dmz_audit_log")
+		ts.where = b.dateScope(ts.where, "c_created")
+		scopes = append(scopes, ts)
+	}
+
+	return
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+// Backups are uploaded with a single signed PUT request
+// (AWS Signature Version 4), which AWS S3, MinIO and most other
+// S3-compatible stores accept.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	m "github.com/documize/community/model/backup"
+)
+
+const (
+	s3DefaultRegion = "us-east-1"
+	s3TimeFormat    = "20060102T150405Z"
+)
+
+// Allows tests to use fixed time and mock server client.
+var (
+	s3Now    = time.Now
+	s3Client = &http.Client{Timeout: 30 * time.Minute}
+)
+
+// validateDestination checks object store settings before backup starts.
+func validateDestination(d m.Destination) error {
+	if len(d.Bucket) == 0 {
+		return errors.New("destination bucket required")
+	}
+	if len(d.AccessKey) == 0 || len(d.SecretKey) == 0 {
+		return errors.New("destination credentials required")
+	}
+	if len(d.Endpoint) > 0 {
+		u, err := url.Parse(d.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.New("destination endpoint must be http or https URL")
+		}
+	}
+
+	return nil
+}
+
+// objectURL returns address of object in destination bucket.
+func objectURL(d m.Destination, name string) (*url.URL, error) {
+	region := d.Region
+	if len(region) == 0 {
+		region = s3DefaultRegion
+	}
+	endpoint := d.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid destination endpoint")
+	}
+
+	key := strings.TrimPrefix(d.Prefix+name, "/")
+	if d.PathStyle {
+		u.Path = u.Path + "/" + d.Bucket + "/" + key
+	} else {
+		u.Host = d.Bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+	}
+	u.RawPath = s3Escape(u.Path)
+
+	return u, nil
+}
+
+// uploadS3 puts backup into destination bucket and returns object URL.
+func uploadS3(d m.Destination, name string, data []byte) (string, error) {
+	u, err := objectURL(d, name)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Wrap(err, "cannot create upload request")
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	signS3(req, d, data, s3Now().UTC())
+
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "backup upload failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("backup upload failed: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return u.String(), nil
+}
+
+// signS3 adds AWS Signature Version 4 authorization to request.
+func signS3(req *http.Request, d m.Destination, payload []byte, now time.Time) {
+	region := d.Region
+	if len(region) == 0 {
+		region = s3DefaultRegion
+	}
+
+	amzDate := now.Format(s3TimeFormat)
+	day := amzDate[:8]
+	payloadHash := archiveChecksum(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, archiveChecksum([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+d.SecretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.AccessKey, scope, signed, signature))
+}
+
+// s3Escape percent-encodes path as S3 expects,
+// leaving only unreserved characters and slashes.
+func s3Escape(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// verifyArchive checks that archive holds every table file listed
+// in manifest and that file contents match recorded size and checksum.
+// Legacy archives without file list are checked for missing tables only.
+func (r *restoreHandler) verifyArchive() error {
+	mf := r.Spec.Manifest
+	if len(mf.ID) == 0 {
+		return errors.New("manifest.json does not describe a backup")
+	}
+
+	var problems []string
+
+	for table := range mf.Counts {
+		if mf.IsExcluded(table) {
+			continue
+		}
+		if !r.hasFile(table + ".json") {
+			problems = append(problems, fmt.Sprintf("%s.json missing", table))
+		}
+	}
+
+	for _, f := range mf.Files {
+		found, b, err := r.readZip(f.Name)
+		if !found {
+			// Already reported above for table files.
+			if _, ok := mf.Counts[strings.TrimSuffix(f.Name, ".json")]; !ok {
+				problems = append(problems, fmt.Sprintf("%s missing", f.Name))
+			}
+			continue
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if int64(len(b)) != f.Size {
+			problems = append(problems, fmt.Sprintf("%s size mismatch", f.Name))
+		} else if archiveChecksum(b) != f.Checksum {
+			problems = append(problems, fmt.Sprintf("%s checksum mismatch", f.Name))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("backup archive failed verification: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package backup
+
+import (
+	"archive/zip"
+	"strings"
+	"testing"
+
+	m "github.com/documize/community/model/backup"
+)
+
+// Opens test archive with manifest describing given files.
+func verifyTestHandler(t *testing.T, mf m.Manifest, files []backupItem) *restoreHandler {
+	zr, err := zip.OpenReader(writeTestZip(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { zr.Close() })
+
+	r := &restoreHandler{Zip: &zr.Reader}
+	r.Spec.Manifest = mf
+
+	return r
+}
+
+func validManifest(t *testing.T, files []backupItem) m.Manifest {
+	mf, err := newManifest("b1", m.ExportSpec{OrgID: "o1"}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mf
+}
+
+func TestVerifyArchive(t *testing.T) {
+	files := []backupItem{
+		{Filename: "dmz_org.json", Content: `[{"refId":"o1"}]`},
+		{Filename: "dmz_doc.json", Content: `[{"refId":"d1"}]`},
+	}
+	mf := validManifest(t, files)
+
+	if err := verifyTestHandler(t, mf, files).verifyArchive(); err != nil {
+		t.Errorf("expected valid archive got %s", err)
+	}
+
+	// Tampered entry.
+	tampered := []backupItem{files[0], {Filename: "dmz_doc.json", Content: `[{"refId":"d2"}]`}}
+	err := verifyTestHandler(t, mf, tampered).verifyArchive()
+	if err == nil || !strings.Contains(err.Error(), "dmz_doc.json checksum mismatch") {
+		t.Errorf("expected checksum mismatch got %v", err)
+	}
+	resized := []backupItem{files[0], {Filename: "dmz_doc.json", Content: `[]`}}
+	err = verifyTestHandler(t, mf, resized).verifyArchive()
+	if err == nil || !strings.Contains(err.Error(), "dmz_doc.json size mismatch") {
+		t.Errorf("expected size mismatch got %v", err)
+	}
+
+	// Truncated archive.
+	err = verifyTestHandler(t, mf, files[:1]).verifyArchive()
+	if err == nil || !strings.Contains(err.Error(), "dmz_doc.json missing") {
+		t.Errorf("expected missing file got %v", err)
+	}
+
+	// Excluded tables are not expected.
+	mf = validManifest(t, files[:1])
+	mf.Counts["dmz_doc"] = 0
+	mf.Excluded = []string{"dmz_doc"}
+	if err := verifyTestHandler(t, mf, files[:1]).verifyArchive(); err != nil {
+		t.Errorf("expected excluded table to be skipped got %s", err)
+	}
+
+	// Legacy archives without file list are checked for missing tables only.
+	legacy := m.Manifest{ID: "b0", Counts: map[string]int{"dmz_org": 1}}
+	if err := verifyTestHandler(t, legacy, tampered).verifyArchive(); err != nil {
+		t.Errorf("expected legacy archive to pass got %s", err)
+	}
+}
+
+func TestVerifyMissingManifest(t *testing.T) {
+	r := verifyTestHandler(t, m.Manifest{}, []backupItem{{Filename: "dmz_org.json", Content: `[]`}})
+
+	if err := r.manifest(); err == nil || err.Error() != "missing manifest.json" {
+		t.Errorf("expected missing manifest error got %v", err)
+	}
+	if err := r.verifyArchive(); err == nil {
+		t.Error("expected empty manifest to fail verification")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+// Package health reports instance liveness and readiness
+// for load balancers and orchestration platforms (e.g. Kubernetes probes).
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain/store"
+	"github.com/documize/community/model/health"
+)
+
+// Health checks must answer quickly even when database hangs.
+const pingTimeout = 2 * time.Second
+
+// Handler contains the runtime information such as logging and database.
+type Handler struct {
+	Runtime *env.Runtime
+	Store   *store.Store
+}
+
+// pinger is satisfied by database handle.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Live reports that process is up and serving HTTP.
+// Database is not checked so that outages do not get instance restarted.
+func (h *Handler) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, health.Report{Status: health.StatusOK, SiteMode: siteMode(h.Runtime.Flags.SiteMode)})
+}
+
+// Ready reports whether instance can serve users: database reachable
+// and schema installed and up to date (site in normal mode).
+// Responds 503 when not ready.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	var db pinger
+	if h.Runtime.Db != nil {
+		db = h.Runtime.Db
+	}
+
+	writeReport(w, readiness(r.Context(), h.Runtime.Flags.SiteMode, db, h.Runtime.Log))
+}
+
+// readiness pings database and combines result with site mode.
+// Ping failures are logged rather than reported as they can
+// reveal database host and driver details to anonymous callers.
+func readiness(ctx context.Context, mode string, db pinger, log env.Logger) health.Report {
+	rp := health.Report{Status: health.StatusOK, SiteMode: siteMode(mode), Database: health.StatusOK}
+
+	if db == nil {
+		rp.Database = "not connected"
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+
+		if err := db.PingContext(ctx); err != nil {
+			rp.Database = "unavailable"
+			log.Error("health check database ping failed", err)
+		}
+	}
+
+	// Setup mode means schema is missing or awaiting first account,
+	// bad database mode means schema is unusable and
+	// offline mode means site was taken down deliberately.
+	if rp.Database != health.StatusOK || rp.SiteMode != health.SiteModeNormal {
+		rp.Status = health.StatusDegraded
+	}
+
+	return rp
+}
+
+func siteMode(mode string) string {
+	switch mode {
+	case env.SiteModeOffline:
+		return health.SiteModeOffline
+	case env.SiteModeSetup:
+		return health.SiteModeSetup
+	case env.SiteModeBadDB:
+		return health.SiteModeBadDB
+	default:
+		return health.SiteModeNormal
+	}
+}
+
+func writeReport(w http.ResponseWriter, rp health.Report) {
+	status := http.StatusOK
+	if rp.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	j, _ := json.Marshal(rp)
+	w.Write(j)
+}
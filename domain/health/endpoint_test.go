@@ -0,0 +1,132 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/model/health"
+)
+
+type stubDB struct {
+	err   error
+	delay time.Duration
+}
+
+func (s stubDB) PingContext(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(message string)                    {}
+func (nopLogger) Infof(message string, a ...interface{}) {}
+func (nopLogger) Trace(message string)                   {}
+func (nopLogger) Error(message string, err error)        {}
+
+// Keeps logged errors for inspection.
+type errorLogger struct {
+	nopLogger
+	errors []error
+}
+
+func (l *errorLogger) Error(message string, err error) { l.errors = append(l.errors, err) }
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		db     pinger
+		status string
+	}{
+		{"healthy", env.SiteModeNormal, stubDB{}, health.StatusOK},
+		{"database down", env.SiteModeNormal, stubDB{err: errors.New("connection refused")}, health.StatusDegraded},
+		{"not connected", env.SiteModeNormal, nil, health.StatusDegraded},
+		{"setup", env.SiteModeSetup, stubDB{}, health.StatusDegraded},
+		{"offline", env.SiteModeOffline, stubDB{}, health.StatusDegraded},
+		{"bad database", env.SiteModeBadDB, stubDB{}, health.StatusDegraded},
+	}
+
+	for _, tc := range tests {
+		rp := readiness(context.Background(), tc.mode, tc.db, nopLogger{})
+		if rp.Status != tc.status {
+			t.Errorf("%s: expected %s got %+v", tc.name, tc.status, rp)
+		}
+	}
+
+	log := &errorLogger{}
+	rp := readiness(context.Background(), env.SiteModeNormal, stubDB{err: errors.New("dial tcp 10.0.0.5:5432: connection refused")}, log)
+	if rp.Database != "unavailable" {
+		t.Errorf("expected database error hidden got %s", rp.Database)
+	}
+	if len(log.errors) != 1 || !strings.Contains(log.errors[0].Error(), "10.0.0.5") {
+		t.Errorf("expected database error logged got %v", log.errors)
+	}
+}
+
+// Hung database must not hang health check.
+func TestReadinessTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	rp := readiness(ctx, env.SiteModeNormal, stubDB{delay: time.Minute}, nopLogger{})
+	if rp.Status != health.StatusDegraded || time.Since(start) > time.Second {
+		t.Errorf("expected quick degraded report got %+v after %s", rp, time.Since(start))
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeReport(w, readiness(context.Background(), env.SiteModeNormal, stubDB{}, nopLogger{}))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	writeReport(w, readiness(context.Background(), env.SiteModeOffline, stubDB{err: errors.New("down")}, nopLogger{}))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 got %d", w.Code)
+	}
+
+	rp := health.Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rp); err != nil {
+		t.Fatal(err)
+	}
+	if rp.Status != health.StatusDegraded || rp.SiteMode != health.SiteModeOffline || rp.Database != "unavailable" {
+		t.Errorf("unexpected report %+v", rp)
+	}
+}
+
+// Liveness ignores database.
+func TestLive(t *testing.T) {
+	h := Handler{Runtime: &env.Runtime{Flags: env.Flags{SiteMode: env.SiteModeOffline}}}
+
+	w := httptest.NewRecorder()
+	h.Live(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 got %d", w.Code)
+	}
+}
@@ -276,6 +276,23 @@ func (s Store) GetDocumentPageMeta(ctx domain.RequestContext, documentID string,
 	return
 }
 
+// GetExternalPageMeta returns meta information for all externally sourced sections
+// across all organizations (system level operation).
+func (s Store) GetExternalPageMeta(ctx domain.RequestContext) (meta []page.Meta, err error) {
+	err = s.Runtime.Db.Select(&meta, `SELECT id, c_sectionid AS sectionid,
+        c_orgid AS orgid, c_userid AS userid, c_docid AS documentid,
+        c_rawbody AS rawbody, coalesce(c_config,`+s.EmptyJSON()+`) as config,
+        c_external AS externalsource, c_created AS created, c_revised AS revised
+        FROM dmz_section_meta
+        WHERE c_external=`+s.IsTrue())
+
+	if err != nil {
+		err = errors.Wrap(err, "get external page meta")
+	}
+
+	return
+}
+
 //**************************************************
 // Table of contents
 //**************************************************
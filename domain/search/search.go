@@ -14,6 +14,8 @@ package search
 import (
 	"database/sql"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/documize/community/domain"
 	"github.com/documize/community/model/attachment"
@@ -132,40 +134,132 @@ func (m *Indexer) Rebuild(ctx domain.RequestContext) {
 	m.runtime.Log.Info(fmt.Sprintf("Search re-indexing started for %d documents", len(docs)))
 
 	for i := range docs {
-		d := docs[i]
+		m.reindexDocument(ctx, docs[i])
 
-		dc, err := m.store.Meta.Document(ctx, d)
-		if err != nil {
-			m.runtime.Log.Error(method, err)
-			// continue
-		}
-		at, err := m.store.Meta.Attachments(ctx, d)
-		if err != nil {
-			m.runtime.Log.Error(method, err)
-			// continue
+		// Log process every N documents.
+		if i%100 == 0 {
+			m.runtime.Log.Info(fmt.Sprintf("Search re-indexed %d documents...", i))
 		}
+	}
+
+	m.runtime.Log.Info(fmt.Sprintf("Search re-indexing finished for %d documents", len(docs)))
+}
+
+// RebuildOptions controls pace of batched search index rebuild.
+type RebuildOptions struct {
+	// Number of documents processed per batch.
+	BatchSize int
+
+	// Number of documents indexed in parallel within a batch.
+	Concurrency int
+
+	// Pause between batches to give search backend breathing space.
+	Pause time.Duration
+}
+
+// DefaultRebuildOptions is a gentle pace suitable for large instances.
+func DefaultRebuildOptions() RebuildOptions {
+	return RebuildOptions{BatchSize: 100, Concurrency: 2, Pause: time.Second}
+}
+
+// RebuildBatched recreates all search indexes in batches,
+// pausing between batches and logging progress as it goes.
+// Used after restore operations to avoid overwhelming database.
+func (m *Indexer) RebuildBatched(ctx domain.RequestContext, opts RebuildOptions) {
+	method := "search.rebuildSearchIndexBatched"
 
-		m.IndexDocument(ctx, dc, at)
+	docs, err := m.store.Meta.Documents(ctx)
+	if err != nil {
+		m.runtime.Log.Error(method, err)
+		return
+	}
 
-		pages, err := m.store.Meta.Pages(ctx, d)
-		if err != nil {
-			m.runtime.Log.Error(method, err)
-			// continue
+	m.reindexBatched(ctx, docs, opts)
+}
+
+// ReindexDocuments recreates search indexes for specified documents only,
+// paced as per RebuildBatched.
+func (m *Indexer) ReindexDocuments(ctx domain.RequestContext, docs []string, opts RebuildOptions) {
+	m.reindexBatched(ctx, docs, opts)
+}
+
+func (m *Indexer) reindexBatched(ctx domain.RequestContext, docs []string, opts RebuildOptions) {
+	def := DefaultRebuildOptions()
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = def.BatchSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = def.Concurrency
+	}
+	if opts.Pause < 0 {
+		opts.Pause = 0
+	}
+
+	m.runtime.Log.Info(fmt.Sprintf("Search re-indexing started for %d documents (batch %d, concurrency %d, pause %s)",
+		len(docs), opts.BatchSize, opts.Concurrency, opts.Pause))
+
+	for start := 0; start < len(docs); start += opts.BatchSize {
+		end := start + opts.BatchSize
+		if end > len(docs) {
+			end = len(docs)
 		}
 
-		for j := range pages {
-			m.IndexContent(ctx, pages[j])
+		// Fan out batch across fixed number of workers.
+		work := make(chan string)
+		var wg sync.WaitGroup
+		for w := 0; w < opts.Concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for id := range work {
+					m.reindexDocument(ctx, id)
+				}
+			}()
 		}
+		for _, id := range docs[start:end] {
+			work <- id
+		}
+		close(work)
+		wg.Wait()
 
-		// Log process every N documents.
-		if i%100 == 0 {
-			m.runtime.Log.Info(fmt.Sprintf("Search re-indexed %d documents...", i))
+		m.runtime.Log.Info(fmt.Sprintf("Search re-indexed %d of %d documents...", end, len(docs)))
+
+		if end < len(docs) && opts.Pause > 0 {
+			time.Sleep(opts.Pause)
 		}
 	}
 
 	m.runtime.Log.Info(fmt.Sprintf("Search re-indexing finished for %d documents", len(docs)))
 }
 
+// Indexes document, attachments and content.
+func (m *Indexer) reindexDocument(ctx domain.RequestContext, documentID string) {
+	method := "search.reindexDocument"
+
+	dc, err := m.store.Meta.Document(ctx, documentID)
+	if err != nil {
+		m.runtime.Log.Error(method, err)
+		// continue
+	}
+	at, err := m.store.Meta.Attachments(ctx, documentID)
+	if err != nil {
+		m.runtime.Log.Error(method, err)
+		// continue
+	}
+
+	m.IndexDocument(ctx, dc, at)
+
+	pages, err := m.store.Meta.Pages(ctx, documentID)
+	if err != nil {
+		m.runtime.Log.Error(method, err)
+		// continue
+	}
+
+	for j := range pages {
+		m.IndexContent(ctx, pages[j])
+	}
+}
+
 // FilterCategoryProtected removes search results that cannot be seen by user
 // due to document cateogory viewing permissions.
 func FilterCategoryProtected(results []sm.QueryResult, cats []category.Category, members []category.Member) (filtered []sm.QueryResult) {
@@ -118,43 +118,15 @@ func (h *Handler) RefreshSections(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		pcontext := provider.NewContext(pm.OrgID, pm.UserID, ctx)
-
-		// Ask for data refresh
-		data, ok := provider.Refresh(page.ContentType, pcontext, pm.Config, pm.RawBody)
-		if !ok {
-			h.Runtime.Log.Info("provider.Refresh could not find: " + page.ContentType)
-		}
-
-		// Render again
-		body, ok := provider.Render(page.ContentType, pcontext, pm.Config, data)
-		if !ok {
-			h.Runtime.Log.Info("provider.Render could not find: " + page.ContentType)
+		changed, err2 := h.refresh(ctx, &page, pm)
+		if err2 != nil {
+			h.Runtime.Log.Error(method, err2)
+			response.WriteServerError(w, method, err2)
+			ctx.Transaction.Rollback()
+			return
 		}
-
-		// Compare to stored render
-		if body != page.Body {
-			// Persist latest data
-			page.Body = body
+		if changed {
 			p = append(p, page)
-
-			refID := uniqueid.Generate()
-
-			err = h.Store.Page.Update(ctx, page, refID, ctx.UserID, false)
-			if err != nil {
-				h.Runtime.Log.Error(method, err)
-				response.WriteServerError(w, method, err)
-				ctx.Transaction.Rollback()
-				return
-			}
-
-			err = h.Store.Page.UpdateMeta(ctx, pm, false) // do not change the UserID on this PageMeta
-			if err != nil {
-				h.Runtime.Log.Error(method, err)
-				response.WriteServerError(w, method, err)
-				ctx.Transaction.Rollback()
-				return
-			}
 		}
 	}
 
@@ -162,3 +134,109 @@ func (h *Handler) RefreshSections(w http.ResponseWriter, r *http.Request) {
 
 	response.WriteJSON(w, p)
 }
+
+// RefreshSection updates a single externally sourced section immediately
+// and returns the freshly rendered section.
+// Refresh persists the section so caller must be able to edit the document.
+func (h *Handler) RefreshSection(w http.ResponseWriter, r *http.Request) {
+	method := "section.refreshSingle"
+	ctx := domain.GetRequestContext(r)
+
+	sectionID := request.Param(r, "sectionID")
+	if len(sectionID) == 0 {
+		response.WriteMissingDataError(w, method, "sectionID")
+		return
+	}
+
+	pg, err := h.Store.Page.Get(ctx, sectionID)
+	if err == sql.ErrNoRows {
+		response.WriteNotFoundError(w, method, sectionID)
+		return
+	}
+	if err != nil {
+		h.Runtime.Log.Error(method, err)
+		response.WriteServerError(w, method, err)
+		return
+	}
+
+	if !permission.CanChangeDocument(ctx, *h.Store, pg.DocumentID) {
+		response.WriteForbiddenError(w)
+		return
+	}
+
+	pm, err := h.Store.Page.GetPageMeta(ctx, sectionID)
+	if err != nil {
+		h.Runtime.Log.Error(method, err)
+		response.WriteServerError(w, method, err)
+		return
+	}
+
+	// Only sections with external data have anything to refresh.
+	if !pm.ExternalSource {
+		response.WriteBadRequestError(w, method, "section is not externally sourced")
+		return
+	}
+
+	ctx.Transaction, err = h.Runtime.Db.Beginx()
+	if err != nil {
+		h.Runtime.Log.Error(method, err)
+		response.WriteServerError(w, method, err)
+		return
+	}
+
+	_, err = h.refresh(ctx, &pg, pm)
+	if err != nil {
+		ctx.Transaction.Rollback()
+		h.Runtime.Log.Error(method, err)
+		response.WriteServerError(w, method, err)
+		return
+	}
+
+	err = ctx.Transaction.Commit()
+	if err != nil {
+		ctx.Transaction.Rollback()
+		h.Runtime.Log.Error(method, err)
+		response.WriteServerError(w, method, err)
+		return
+	}
+
+	response.WriteJSON(w, pg)
+}
+
+// Asks section provider for latest data and renders again,
+// persisting the section if rendered output has changed.
+func (h *Handler) refresh(ctx domain.RequestContext, pg *page.Page, pm page.Meta) (changed bool, err error) {
+	pcontext := provider.NewContext(pm.OrgID, pm.UserID, ctx)
+
+	// Ask for data refresh
+	data, ok := provider.Refresh(pg.ContentType, pcontext, pm.Config, pm.RawBody)
+	if !ok {
+		h.Runtime.Log.Info("provider.Refresh could not find: " + pg.ContentType)
+	}
+
+	// Render again
+	body, ok := provider.Render(pg.ContentType, pcontext, pm.Config, data)
+	if !ok {
+		h.Runtime.Log.Info("provider.Render could not find: " + pg.ContentType)
+	}
+
+	// Compare to stored render
+	if body == pg.Body {
+		return false, nil
+	}
+
+	// Persist latest data
+	pg.Body = body
+
+	err = h.Store.Page.Update(ctx, *pg, uniqueid.Generate(), ctx.UserID, false)
+	if err != nil {
+		return
+	}
+
+	err = h.Store.Page.UpdateMeta(ctx, pm, false) // do not change the UserID on this PageMeta
+	if err != nil {
+		return
+	}
+
+	return true, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package section
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/store"
+	"github.com/documize/community/model/doc"
+	"github.com/documize/community/model/page"
+	pm "github.com/documize/community/model/permission"
+	"github.com/gorilla/mux"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(message string)                    {}
+func (nopLogger) Infof(message string, a ...interface{}) {}
+func (nopLogger) Trace(message string)                   {}
+func (nopLogger) Error(message string, err error)        {}
+
+type stubPages struct {
+	store.PageStorer
+	meta page.Meta
+}
+
+func (s stubPages) Get(ctx domain.RequestContext, pageID string) (page.Page, error) {
+	p := page.Page{DocumentID: "d1"}
+	p.RefID = pageID
+	return p, nil
+}
+
+func (s stubPages) GetPageMeta(ctx domain.RequestContext, pageID string) (page.Meta, error) {
+	return s.meta, nil
+}
+
+type stubDocuments struct {
+	store.DocumentStorer
+}
+
+func (stubDocuments) Get(ctx domain.RequestContext, id string) (doc.Document, error) {
+	d := doc.Document{SpaceID: "s1"}
+	d.RefID = id
+	return d, nil
+}
+
+type stubPermissions struct {
+	store.PermissionStorer
+	actions []pm.Action
+}
+
+func (s stubPermissions) GetUserSpacePermissions(ctx domain.RequestContext, spaceID string) (r []pm.Permission, err error) {
+	for _, a := range s.actions {
+		r = append(r, pm.Permission{RefID: spaceID, Location: pm.LocationSpace, Scope: pm.ScopeRow, Action: a})
+	}
+	return
+}
+
+func refreshSection(h *Handler) int {
+	r := httptest.NewRequest(http.MethodPost, "/api/sections/p1/refresh", nil)
+	r = mux.SetURLVars(r, map[string]string{"sectionID": "p1"})
+	w := httptest.NewRecorder()
+
+	h.RefreshSection(w, r)
+
+	return w.Code
+}
+
+// Refresh writes to database so it is limited to editors
+// and sections that have external data.
+func TestRefreshSectionRejected(t *testing.T) {
+	s := &store.Store{
+		Page:       stubPages{meta: page.Meta{ExternalSource: true}},
+		Document:   stubDocuments{},
+		Permission: stubPermissions{actions: []pm.Action{pm.SpaceView}},
+	}
+	h := &Handler{Runtime: &env.Runtime{Log: nopLogger{}}, Store: s}
+
+	if code := refreshSection(h); code != http.StatusForbidden {
+		t.Errorf("expected viewer to be refused with %d got %d", http.StatusForbidden, code)
+	}
+
+	s.Permission = stubPermissions{actions: []pm.Action{pm.SpaceView, pm.DocumentEdit}}
+	s.Page = stubPages{meta: page.Meta{ExternalSource: false}}
+	if code := refreshSection(h); code != http.StatusBadRequest {
+		t.Errorf("expected internal section to be refused with %d got %d", http.StatusBadRequest, code)
+	}
+}
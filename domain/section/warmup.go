@@ -0,0 +1,262 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package section
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/store"
+	"github.com/documize/community/model/page"
+)
+
+// Pause between section refreshes so that external APIs are not hammered.
+const warmupPause = 500 * time.Millisecond
+
+// Sections that failed are not retried until backoff expires.
+// Backoff doubles with each consecutive failure.
+const (
+	warmupBackoffBase = 5 * time.Minute
+	warmupBackoffMax  = 6 * time.Hour
+)
+
+var errWarmupTimeout = errors.New("section refresh timed out")
+
+// warmupOptions control pace and limits of warmup runs.
+type warmupOptions struct {
+	Concurrency int
+	Timeout     time.Duration // per section
+	Budget      time.Duration // per run
+	Interval    time.Duration // zero runs once
+	Pause       time.Duration
+}
+
+// warmupSettings reads warmup options from flags, using defaults
+// for missing or invalid values.
+func warmupSettings(f env.Flags) warmupOptions {
+	opts := warmupOptions{Concurrency: 2, Timeout: 30 * time.Second, Budget: 10 * time.Minute, Pause: warmupPause}
+
+	if n, err := strconv.Atoi(f.WarmupConcurrency); err == nil && n > 0 {
+		opts.Concurrency = n
+	}
+	if d, err := time.ParseDuration(f.WarmupTimeout); err == nil && d > 0 {
+		opts.Timeout = d
+	}
+	if d, err := time.ParseDuration(f.WarmupBudget); err == nil && d > 0 {
+		opts.Budget = d
+	}
+	if d, err := time.ParseDuration(f.WarmupInterval); err == nil && d > 0 {
+		opts.Interval = d
+	}
+
+	return opts
+}
+
+// Warmup refreshes all externally sourced sections in the background
+// so that first viewer of a document does not pay fetch latency.
+// Enabled via -sectionwarmup=true.
+func Warmup(rt *env.Runtime, s *store.Store) {
+	if enabled, _ := strconv.ParseBool(rt.Flags.SectionWarmup); !enabled {
+		return
+	}
+	if rt.Flags.SiteMode == env.SiteModeOffline {
+		return
+	}
+
+	opts := warmupSettings(rt.Flags)
+	h := &Handler{Runtime: rt, Store: s}
+	b := newBackoff(warmupBackoffBase, warmupBackoffMax)
+
+	go func() {
+		for {
+			warmup(h, b, opts)
+			if opts.Interval == 0 {
+				return
+			}
+			time.Sleep(opts.Interval)
+		}
+	}()
+}
+
+func warmup(h *Handler, b *backoff, opts warmupOptions) {
+	method := "section.warmup"
+
+	meta, err := h.Store.Page.GetExternalPageMeta(domain.RequestContext{})
+	if err != nil {
+		h.Runtime.Log.Error(method, err)
+		return
+	}
+
+	h.Runtime.Log.Info(fmt.Sprintf("Section warmup started for %d sections", len(meta)))
+
+	deadline := time.Now().Add(opts.Budget)
+	work := make(chan page.Meta)
+	var warmed, skipped, failed int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pm := range work {
+				err := h.warmSection(pm, opts.Timeout)
+				switch {
+				case err == sql.ErrNoRows:
+					atomic.AddInt64(&skipped, 1)
+				case err != nil:
+					h.Runtime.Log.Error(fmt.Sprintf("%s %s", method, pm.SectionID), err)
+					b.fail(pm.SectionID, time.Now())
+					atomic.AddInt64(&failed, 1)
+				default:
+					b.succeed(pm.SectionID)
+					atomic.AddInt64(&warmed, 1)
+				}
+				time.Sleep(opts.Pause)
+			}
+		}()
+	}
+
+	for i, pm := range meta {
+		if time.Now().After(deadline) {
+			h.Runtime.Log.Info(fmt.Sprintf("Section warmup time budget of %s used up", opts.Budget))
+			atomic.AddInt64(&skipped, int64(len(meta)-i))
+			break
+		}
+		if b.skip(pm.SectionID, time.Now()) {
+			atomic.AddInt64(&skipped, 1)
+			continue
+		}
+		work <- pm
+	}
+	close(work)
+	wg.Wait()
+
+	h.Runtime.Log.Info(fmt.Sprintf("Section warmup finished, %d warmed, %d skipped, %d failed", warmed, skipped, failed))
+}
+
+// warmSection refreshes single section giving up after timeout.
+// Refresh that completes after timeout is rolled back.
+// Commit and timeout are decided under lock so that
+// a timed out refresh is never committed.
+func (h *Handler) warmSection(pm page.Meta, timeout time.Duration) error {
+	var mu sync.Mutex
+	var abandoned, committed bool
+	done := make(chan error, 1)
+
+	go func() {
+		ctx := domain.RequestContext{OrgID: pm.OrgID, UserID: pm.UserID}
+
+		pg, err := h.Store.Page.Get(ctx, pm.SectionID)
+		if err != nil {
+			done <- err
+			return
+		}
+
+		ctx.Transaction, err = h.Runtime.Db.Beginx()
+		if err != nil {
+			done <- err
+			return
+		}
+
+		_, err = h.refresh(ctx, &pg, pm)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil || abandoned {
+			ctx.Transaction.Rollback()
+			done <- err
+			return
+		}
+
+		committed = true
+		done <- ctx.Transaction.Commit()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Refresh finished while we waited for lock.
+		if committed {
+			return <-done
+		}
+
+		abandoned = true
+		return errWarmupTimeout
+	}
+}
+
+// backoff tracks sections that failed to refresh.
+type backoff struct {
+	sync.Mutex
+	base     time.Duration
+	max      time.Duration
+	failures map[string]warmupFailure
+}
+
+type warmupFailure struct {
+	count int
+	last  time.Time
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max, failures: make(map[string]warmupFailure)}
+}
+
+// skip returns true if section failed recently.
+func (b *backoff) skip(id string, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	f, ok := b.failures[id]
+	if !ok {
+		return false
+	}
+
+	wait := b.base
+	for i := 1; i < f.count && wait < b.max; i++ {
+		wait *= 2
+	}
+	if wait > b.max {
+		wait = b.max
+	}
+
+	return now.Before(f.last.Add(wait))
+}
+
+func (b *backoff) fail(id string, now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	f := b.failures[id]
+	f.count++
+	f.last = now
+	b.failures[id] = f
+}
+
+func (b *backoff) succeed(id string) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.failures, id)
+}
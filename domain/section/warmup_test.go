@@ -0,0 +1,66 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package section
+
+import (
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+)
+
+func TestWarmupSettings(t *testing.T) {
+	opts := warmupSettings(env.Flags{})
+	if opts.Concurrency != 2 || opts.Timeout != 30*time.Second || opts.Budget != 10*time.Minute || opts.Interval != 0 {
+		t.Errorf("unexpected defaults %+v", opts)
+	}
+
+	opts = warmupSettings(env.Flags{WarmupConcurrency: "8", WarmupTimeout: "5s", WarmupBudget: "1h", WarmupInterval: "bad"})
+	if opts.Concurrency != 8 || opts.Timeout != 5*time.Second || opts.Budget != time.Hour || opts.Interval != 0 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Minute, 3*time.Minute)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if b.skip("s1", now) {
+		t.Error("expected no skip before failure")
+	}
+
+	b.fail("s1", now)
+	if !b.skip("s1", now.Add(59*time.Second)) {
+		t.Error("expected skip within first backoff")
+	}
+	if b.skip("s1", now.Add(time.Minute)) {
+		t.Error("expected no skip after first backoff")
+	}
+
+	// Second failure doubles wait.
+	b.fail("s1", now)
+	if !b.skip("s1", now.Add(90*time.Second)) {
+		t.Error("expected skip within doubled backoff")
+	}
+
+	// Wait is capped.
+	b.fail("s1", now)
+	b.fail("s1", now)
+	if b.skip("s1", now.Add(3*time.Minute)) {
+		t.Error("expected backoff to be capped")
+	}
+
+	b.succeed("s1")
+	if b.skip("s1", now) {
+		t.Error("expected no skip after success")
+	}
+}
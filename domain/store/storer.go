@@ -17,6 +17,7 @@ import (
 	"github.com/documize/community/model/activity"
 	"github.com/documize/community/model/attachment"
 	"github.com/documize/community/model/audit"
+	"github.com/documize/community/model/backup"
 	"github.com/documize/community/model/block"
 	"github.com/documize/community/model/category"
 	"github.com/documize/community/model/doc"
@@ -38,6 +39,7 @@ type Store struct {
 	Activity     ActivityStorer
 	Attachment   AttachmentStorer
 	Audit        AuditStorer
+	Backup       BackupStorer
 	Block        BlockStorer
 	Category     CategoryStorer
 	Document     DocumentStorer
@@ -277,6 +279,7 @@ type PageStorer interface {
 	Delete(ctx domain.RequestContext, documentID, pageID string) (rows int64, err error)
 	GetPageMeta(ctx domain.RequestContext, pageID string) (meta page.Meta, err error)
 	GetDocumentPageMeta(ctx domain.RequestContext, documentID string, externalSourceOnly bool) (meta []page.Meta, err error)
+	GetExternalPageMeta(ctx domain.RequestContext) (meta []page.Meta, err error)
 	UpdateMeta(ctx domain.RequestContext, meta page.Meta, updateUserID bool) (err error)
 	UpdateSequence(ctx domain.RequestContext, documentID, pageID string, sequence float64) (err error)
 	UpdateLevel(ctx domain.RequestContext, documentID, pageID string, level int) (err error)
@@ -324,3 +327,8 @@ type LabelStorer interface {
 type OnboardStorer interface {
 	ContentCounts(orgID string) (spaces, docs int)
 }
+
+// BackupStorer defines required methods for backup sizing.
+type BackupStorer interface {
+	CountRows(ctx domain.RequestContext, spec backup.ExportSpec) (counts map[string]int, err error)
+}
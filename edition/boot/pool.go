@@ -0,0 +1,53 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package boot
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/documize/community/core/env"
+	"github.com/jmoiron/sqlx"
+)
+
+// dbPool holds database connection pool limits.
+type dbPool struct {
+	MaxIdle     int
+	MaxOpen     int
+	MaxLifetime time.Duration
+}
+
+// dbPoolSettings reads pool limits from flags,
+// keeping defaults for values that are absent or invalid.
+func dbPoolSettings(f env.Flags) dbPool {
+	p := dbPool{MaxIdle: 30, MaxOpen: 100, MaxLifetime: 4 * time.Hour}
+
+	if n, err := strconv.Atoi(f.DBMaxIdleConns); err == nil && n >= 0 {
+		p.MaxIdle = n
+	}
+	// Zero means unlimited open connections and connections reused forever.
+	if n, err := strconv.Atoi(f.DBMaxOpenConns); err == nil && n >= 0 {
+		p.MaxOpen = n
+	}
+	if d, err := time.ParseDuration(f.DBConnMaxLifetime); err == nil && d >= 0 {
+		p.MaxLifetime = d
+	}
+
+	return p
+}
+
+// apply sets pool limits on database handle.
+func (p dbPool) apply(db *sqlx.DB) {
+	db.SetMaxOpenConns(p.MaxOpen)
+	db.SetMaxIdleConns(p.MaxIdle)
+	db.SetConnMaxLifetime(p.MaxLifetime)
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package boot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDBPoolSettings(t *testing.T) {
+	p := dbPoolSettings(env.Flags{})
+	if p.MaxIdle != 30 || p.MaxOpen != 100 || p.MaxLifetime != 4*time.Hour {
+		t.Errorf("unexpected defaults %+v", p)
+	}
+
+	p = dbPoolSettings(env.Flags{DBMaxIdleConns: "5", DBMaxOpenConns: "10", DBConnMaxLifetime: "30m"})
+	if p.MaxIdle != 5 || p.MaxOpen != 10 || p.MaxLifetime != 30*time.Minute {
+		t.Errorf("flags did not override defaults %+v", p)
+	}
+
+	p = dbPoolSettings(env.Flags{DBMaxIdleConns: "-1", DBMaxOpenConns: "-5", DBConnMaxLifetime: "bad"})
+	if p.MaxIdle != 30 || p.MaxOpen != 100 || p.MaxLifetime != 4*time.Hour {
+		t.Errorf("invalid flags should keep defaults %+v", p)
+	}
+
+	// Zero is a valid setting: no idle connections, unlimited open
+	// connections and connections reused forever.
+	p = dbPoolSettings(env.Flags{DBMaxIdleConns: "0", DBMaxOpenConns: "0", DBConnMaxLifetime: "0"})
+	if p.MaxIdle != 0 || p.MaxOpen != 0 || p.MaxLifetime != 0 {
+		t.Errorf("explicit zero should override defaults %+v", p)
+	}
+}
+
+func TestDBPoolApply(t *testing.T) {
+	// Opening does not connect so no server is needed.
+	db, err := sqlx.Open("mysql", "user:pass@tcp(127.0.0.1:3306)/documize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dbPoolSettings(env.Flags{DBMaxOpenConns: "7"}).apply(db)
+
+	if n := db.Stats().MaxOpenConnections; n != 7 {
+		t.Errorf("expected 7 got %d", n)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package boot
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/documize/community/core/env"
+)
+
+// Retry waits double each time up to this limit.
+const dbRetryMaxInterval = 30 * time.Second
+
+// dbRetry controls database connection retries at startup.
+type dbRetry struct {
+	Retries  int
+	Interval time.Duration
+}
+
+// dbRetrySettings reads retry settings from flags,
+// keeping defaults for values that are absent or invalid.
+func dbRetrySettings(f env.Flags) dbRetry {
+	rt := dbRetry{Retries: 5, Interval: time.Second}
+
+	if n, err := strconv.Atoi(f.DBConnectRetries); err == nil && n >= 0 {
+		rt.Retries = n
+	}
+	if d, err := time.ParseDuration(f.DBConnectRetryInterval); err == nil && d > 0 {
+		rt.Interval = d
+	}
+
+	return rt
+}
+
+// pingRetry pings database until it answers or retries run out,
+// backing off exponentially between attempts.
+// Last error is returned if database never answers.
+func pingRetry(r *env.Runtime, ping func() error, retry dbRetry, sleep func(time.Duration)) (err error) {
+	wait := retry.Interval
+
+	for attempt := 1; ; attempt++ {
+		err = ping()
+		if err == nil {
+			if attempt > 1 {
+				r.Log.Infof("Database connection established after %d attempts", attempt)
+			}
+			return nil
+		}
+		if attempt > retry.Retries {
+			return err
+		}
+
+		r.Log.Infof("Database not reachable (attempt %d of %d), retrying in %s: %s", attempt, retry.Retries+1, wait, err.Error())
+		sleep(wait)
+
+		wait *= 2
+		if wait > dbRetryMaxInterval {
+			wait = dbRetryMaxInterval
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package boot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+)
+
+func TestDBRetrySettings(t *testing.T) {
+	rt := dbRetrySettings(env.Flags{})
+	if rt.Retries != 5 || rt.Interval != time.Second {
+		t.Errorf("unexpected defaults %+v", rt)
+	}
+
+	rt = dbRetrySettings(env.Flags{DBConnectRetries: "0", DBConnectRetryInterval: "250ms"})
+	if rt.Retries != 0 || rt.Interval != 250*time.Millisecond {
+		t.Errorf("flags did not override defaults %+v", rt)
+	}
+
+	rt = dbRetrySettings(env.Flags{DBConnectRetries: "-1", DBConnectRetryInterval: "bad"})
+	if rt.Retries != 5 || rt.Interval != time.Second {
+		t.Errorf("invalid flags should keep defaults %+v", rt)
+	}
+}
+
+// Database answers on third attempt.
+func TestPingRetry(t *testing.T) {
+	log := &testLogger{}
+	r := &env.Runtime{Log: log}
+
+	calls := 0
+	ping := func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	var waits []time.Duration
+	sleep := func(d time.Duration) { waits = append(waits, d) }
+
+	err := pingRetry(r, ping, dbRetry{Retries: 5, Interval: time.Second}, sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts got %d", calls)
+	}
+	if len(waits) != 2 || waits[0] != time.Second || waits[1] != 2*time.Second {
+		t.Errorf("expected exponential backoff got %v", waits)
+	}
+	if !log.logged("attempt 2 of 6") || !log.logged("established after 3 attempts") {
+		t.Errorf("expected attempts to be logged %v", log.messages)
+	}
+}
+
+func TestPingRetryExhausted(t *testing.T) {
+	r := &env.Runtime{Log: &testLogger{}}
+
+	calls := 0
+	ping := func() error {
+		calls++
+		return errors.New("connection refused")
+	}
+	var waits []time.Duration
+	sleep := func(d time.Duration) { waits = append(waits, d) }
+
+	err := pingRetry(r, ping, dbRetry{Retries: 6, Interval: 10 * time.Second}, sleep)
+	if err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected last error got %v", err)
+	}
+	if calls != 7 {
+		t.Errorf("expected 7 attempts got %d", calls)
+	}
+	// Backoff is capped.
+	if waits[len(waits)-1] != dbRetryMaxInterval {
+		t.Errorf("expected capped backoff got %v", waits)
+	}
+
+	// No retries configured fails straight away.
+	calls = 0
+	if pingRetry(r, ping, dbRetry{}, sleep) == nil || calls != 1 {
+		t.Errorf("expected single attempt got %d", calls)
+	}
+}
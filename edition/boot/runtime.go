@@ -13,12 +13,16 @@
 package boot
 
 import (
+	"io/ioutil"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/documize/community/core/database"
 	"github.com/documize/community/core/env"
 	"github.com/documize/community/core/secrets"
+	"github.com/documize/community/domain/backup"
 	"github.com/documize/community/domain/store"
 	"github.com/documize/community/edition/storage"
 	"github.com/jmoiron/sqlx"
@@ -26,6 +30,18 @@ import (
 
 // InitRuntime prepares runtime using command line and environment variables.
 func InitRuntime(r *env.Runtime, s *store.Store) bool {
+	return initRuntime(r, s, nil)
+}
+
+// InitRuntimeWithDB prepares runtime using an already configured database handle
+// instead of opening a new connection, e.g. for integration tests or embedding.
+// Connection pool settings are left to the caller.
+// Database is still pinged and migrated as required.
+func InitRuntimeWithDB(r *env.Runtime, s *store.Store, db *sqlx.DB) bool {
+	return initRuntime(r, s, db)
+}
+
+func initRuntime(r *env.Runtime, s *store.Store, db *sqlx.DB) bool {
 	// We need SALT to hash auth JWT tokens.
 	if r.Flags.Salt == "" {
 		r.Flags.Salt = secrets.RandSalt()
@@ -48,47 +64,51 @@ func InitRuntime(r *env.Runtime, s *store.Store) bool {
 		}
 	}
 
-	// Set up required storage provider.
-	switch r.Flags.DBType {
-	case "mysql":
-		storage.SetMySQLProvider(r, s)
-	case "mariadb":
-		storage.SetMySQLProvider(r, s)
-	case "percona":
-		storage.SetMySQLProvider(r, s)
-	case "postgresql":
-		storage.SetPostgreSQLProvider(r, s)
-	case "sqlserver":
-		storage.SetSQLServerProvider(r, s)
-	default:
-		r.Log.Infof("Unsupported database type: %s", r.Flags.DBType)
-		r.Log.Info("Documize Community supports the following database types: mysql mariadb percona postgresql sqlserver")
-		os.Exit(1)
+	// Backups are written to temp folder that must be usable.
+	if !checkBackupTempDir(r) {
 		return false
 	}
 
-	// Open connection to database.
-	db, err := sqlx.Open(r.StoreProvider.DriverName(), r.StoreProvider.MakeConnectionString())
-	if err != nil {
-		r.Log.Error("Unable to open database", err)
-		os.Exit(1)
+	// Pull database password from credential provider if so configured.
+	if !resolveDBPassword(r) {
 		return false
 	}
 
-	// Set the database handle.
-	r.Db = db
+	// Set up required storage provider.
+	injected := db != nil
+	if !setStoreProvider(r, s) {
+		if !injected {
+			os.Exit(1)
+		}
+		return false
+	}
 
-	// Set connection defaults.
-	r.Db.SetMaxIdleConns(30)
-	r.Db.SetMaxOpenConns(100)
-	r.Db.SetConnMaxLifetime(time.Second * 14400)
+	// Open connection to database unless one was provided.
+	var err error
+	if !injected {
+		db, err = openDB(r)
+		if err != nil {
+			r.Log.Error("Unable to open database", err)
+			r.Log.Info(r.StoreProvider.Example())
+			os.Exit(1)
+			return false
+		}
 
-	// Ping verifies a connection to the database is still alive, establishing a connection if necessary.
-	err = r.Db.Ping()
+		// Set connection pool limits.
+		pool := dbPoolSettings(r.Flags)
+		if pool.MaxOpen > 0 && pool.MaxIdle > pool.MaxOpen {
+			r.Log.Infof("Database idle connections (%d) exceed open connections (%d), idle pool will be capped", pool.MaxIdle, pool.MaxOpen)
+		}
+		pool.apply(db)
+	}
+
+	err = attachDB(r, db)
 	if err != nil {
 		r.Log.Error("Unable to connect to database", err)
 		r.Log.Info(r.StoreProvider.Example())
-		os.Exit(1)
+		if !injected {
+			os.Exit(1)
+		}
 		return false
 	}
 
@@ -99,8 +119,108 @@ func InitRuntime(r *env.Runtime, s *store.Store) bool {
 				r.Log.Error("unable to run database migration", err)
 				return false
 			}
+		} else if r.Flags.SiteMode == env.SiteModeSetup && len(r.Flags.RestoreFile) > 0 {
+			// Empty database is populated from system backup.
+			r.Log.Info("Restoring empty database from " + r.Flags.RestoreFile)
+			if err := backup.RestoreEmpty(r, s, r.Flags.RestoreFile); err != nil {
+				r.Log.Error("unable to restore database", err)
+				return false
+			}
+			database.Check(r)
+		} else if r.Flags.SiteMode == env.SiteModeSetup {
+			// Empty database gets provider specific schema up front,
+			// leaving only account details for setup mode.
+			if err := database.Bootstrap(r); err != nil {
+				r.Log.Error("unable to create database schema", err)
+				return false
+			}
 		}
 	}
 
 	return true
 }
+
+// attachDB sets runtime database handle and verifies connection.
+func attachDB(r *env.Runtime, db *sqlx.DB) error {
+	// Set the database handle.
+	r.Db = db
+
+	// Ping verifies a connection to the database is still alive, establishing a connection if necessary.
+	// Database may still be starting up so we give it a few chances.
+	return pingRetry(r, r.Db.Ping, dbRetrySettings(r.Flags), time.Sleep)
+}
+
+// Placeholder within database connection string that is replaced
+// with the password obtained from credential provider.
+const passwordPlaceholder = "{password}"
+
+// resolveDBPassword fetches database password using DBPasswordRef
+// and places it into the connection string.
+// Keeps plaintext password out of config files and environment variables.
+func resolveDBPassword(r *env.Runtime) bool {
+	if len(r.Flags.DBPasswordRef) == 0 {
+		return true
+	}
+
+	if !strings.Contains(r.Flags.DBConn, passwordPlaceholder) {
+		r.Log.Infof("Database connection string must contain %s when using -dbpasswordref", passwordPlaceholder)
+		return false
+	}
+
+	pwd, err := secrets.ResolveCredential(r.Flags.DBPasswordRef)
+	if err != nil {
+		r.Log.Error("Unable to resolve database password", err)
+		return false
+	}
+
+	// SQL Server uses URL format so password must be escaped.
+	if r.Flags.DBType == "sqlserver" {
+		pwd = strings.TrimPrefix(url.UserPassword("", pwd).String(), ":")
+	}
+
+	r.Flags.DBConn = strings.Replace(r.Flags.DBConn, passwordPlaceholder, pwd, -1)
+
+	return true
+}
+
+// setStoreProvider sets up storage provider for configured database type.
+// Returns false if type is not supported or provider failed to set itself up.
+func setStoreProvider(r *env.Runtime, s *store.Store) bool {
+	setProvider, err := storage.Lookup(r.Flags.DBType)
+	if err != nil {
+		r.Log.Infof("Unsupported database type %q, supported: %s", r.Flags.DBType, strings.Join(storage.Names(), " "))
+		return false
+	}
+
+	setProvider(r, s)
+	if r.StoreProvider == nil {
+		r.Log.Infof("Database type %q has no storage provider, supported: %s", r.Flags.DBType, strings.Join(storage.Names(), " "))
+		return false
+	}
+
+	return true
+}
+
+// checkBackupTempDir defaults backup folder to OS temp folder
+// and verifies folder exists and is writable.
+func checkBackupTempDir(r *env.Runtime) bool {
+	if len(r.Flags.BackupTempDir) == 0 {
+		r.Flags.BackupTempDir = os.TempDir()
+	}
+
+	info, err := os.Stat(r.Flags.BackupTempDir)
+	if err != nil || !info.IsDir() {
+		r.Log.Infof("Backup temp folder does not exist: %s", r.Flags.BackupTempDir)
+		return false
+	}
+
+	f, err := ioutil.TempFile(r.Flags.BackupTempDir, "dmz-check-")
+	if err != nil {
+		r.Log.Error("Backup temp folder is not writable: "+r.Flags.BackupTempDir, err)
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	return true
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package boot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain/store"
+	"github.com/documize/community/edition/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+// Provider that fails to set itself up.
+func init() {
+	storage.Register("stubdb", func(r *env.Runtime, s *store.Store) {})
+}
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Info(message string) { l.messages = append(l.messages, message) }
+func (l *testLogger) Infof(message string, a ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(message, a...))
+}
+func (l *testLogger) Trace(message string)            {}
+func (l *testLogger) Error(message string, err error) { l.messages = append(l.messages, message) }
+
+func (l *testLogger) logged(s string) bool {
+	for _, m := range l.messages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetStoreProvider(t *testing.T) {
+	log := &testLogger{}
+	r := &env.Runtime{Log: log, Flags: env.Flags{DBType: "oracle"}}
+
+	if setStoreProvider(r, &store.Store{}) {
+		t.Error("expected unknown database type to fail")
+	}
+	if !log.logged(`Unsupported database type "oracle", supported: `) || !log.logged("mysql") {
+		t.Errorf("expected supported types to be logged %v", log.messages)
+	}
+
+	r.Flags.DBType = "stubdb"
+	if setStoreProvider(r, &store.Store{}) {
+		t.Error("expected provider without store to fail")
+	}
+
+	r.Flags.DBType = "mysql"
+	if !setStoreProvider(r, &store.Store{}) || r.StoreProvider == nil {
+		t.Error("expected mysql provider")
+	}
+}
+
+// Unknown database type must fail without touching database.
+func TestInitRuntimeUnknownType(t *testing.T) {
+	log := &testLogger{}
+	r := &env.Runtime{Log: log, Flags: env.Flags{DBType: "oracle", Salt: "salt"}}
+
+	if InitRuntimeWithDB(r, &store.Store{}, &sqlx.DB{}) {
+		t.Error("expected failure")
+	}
+	if r.StoreProvider != nil {
+		t.Error("expected no storage provider")
+	}
+}
+
+// Connector that counts connections made through it.
+type stubConnector struct {
+	connects int
+}
+
+func (c *stubConnector) Connect(context.Context) (driver.Conn, error) {
+	c.connects++
+	return stubConn{}, nil
+}
+
+func (c *stubConnector) Driver() driver.Driver { return nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (stubConn) Close() error                              { return nil }
+func (stubConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+// Injected database handle must be used as is.
+func TestInitRuntimeWithDB(t *testing.T) {
+	log := &testLogger{}
+	r := &env.Runtime{Log: log, Flags: env.Flags{DBType: "mysql", Salt: "salt",
+		SiteMode: env.SiteModeOffline, DBConn: "nobody@tcp(127.0.0.1:1)/none"}}
+
+	c := &stubConnector{}
+	db := sqlx.NewDb(sql.OpenDB(c), "mysql")
+	defer db.Close()
+
+	if !InitRuntimeWithDB(r, &store.Store{}, db) {
+		t.Fatalf("expected success %v", log.messages)
+	}
+	if r.Db != db {
+		t.Error("expected runtime to use injected database handle")
+	}
+	if c.connects != 1 {
+		t.Errorf("expected ping through injected handle only, got %d connections", c.connects)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package boot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/core/socks"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Implemented by storage providers that can validate
+// connection string ahead of opening database.
+type connectionChecker interface {
+	CheckConnectionString() error
+}
+
+// openDB opens database using storage provider, routing connections
+// through SOCKS5 proxy when -dbsocksproxy is set.
+func openDB(r *env.Runtime) (*sqlx.DB, error) {
+	// Catch incomplete connection strings before driver does.
+	if c, ok := r.StoreProvider.(connectionChecker); ok {
+		if err := c.CheckConnectionString(); err != nil {
+			return nil, err
+		}
+	}
+
+	driverName := r.StoreProvider.DriverName()
+	dsn := r.StoreProvider.MakeConnectionString()
+
+	if len(r.Flags.DBSocksProxy) == 0 {
+		return sqlx.Open(driverName, dsn)
+	}
+
+	d, err := socks.New(r.Flags.DBSocksProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Log.Infof("Database connections routed through SOCKS5 proxy %s", d.ProxyAddr)
+
+	switch driverName {
+	case "mysql":
+		// Replaces dialer for all MySQL TCP connections.
+		mysql.RegisterDialContext("tcp", func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		})
+		return sqlx.Open(driverName, dsn)
+	case "postgres":
+		// Keep driver name so that query placeholders are bound correctly.
+		return sqlx.NewDb(sql.OpenDB(pqSocksConnector{dsn: dsn, dialer: d}), driverName), nil
+	}
+
+	return nil, fmt.Errorf("database type %s does not support -dbsocksproxy", r.Flags.DBType)
+}
+
+// PostgreSQL connector that dials through SOCKS5 proxy.
+type pqSocksConnector struct {
+	dsn    string
+	dialer pq.Dialer
+}
+
+func (c pqSocksConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c.dialer, c.dsn)
+}
+
+func (c pqSocksConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
@@ -13,18 +13,23 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/documize/community/core/env"
 	"github.com/documize/community/core/i18n"
 	"github.com/documize/community/domain"
+	"github.com/documize/community/domain/backup"
 	"github.com/documize/community/domain/section"
 	"github.com/documize/community/domain/store"
 	"github.com/documize/community/edition/boot"
 	"github.com/documize/community/edition/logging"
 	"github.com/documize/community/server"
+	"github.com/pkg/errors"
 )
 
 //go:embed static/*
@@ -37,6 +42,13 @@ func main() {
 	// Wire up logging implementation.
 	rt.Log = logging.NewLogger(false)
 
+	// Backup and restore commands run instead of web server.
+	// Archives may go to stdout so logging moves to stderr.
+	cmd := backup.ParseCommand()
+	if cmd != nil {
+		rt.Log = logging.NewWriterLogger(os.Stderr, false)
+	}
+
 	// Specify the product edition.
 	rt.Product = domain.Product{}
 	rt.Product.Major = "5"
@@ -69,13 +81,54 @@ func main() {
 		rt.Log.Error("i18n", err)
 	}
 
+	// Long running work is cancelled once server shuts down.
+	var stop context.CancelFunc
+	rt.Shutdown, stop = context.WithCancel(context.Background())
+
 	// Start database init.
-	boot.InitRuntime(&rt, &s)
+	initOK := boot.InitRuntime(&rt, &s)
+	if !initOK {
+		rt.Log.Error("Unable to start", errors.New("runtime initialization failed"))
+		os.Exit(1)
+	}
+
+	if cmd != nil {
+		runCommand(&rt, &s, cmd, stop)
+		return
+	}
 
 	// Register document sections.
 	section.Register(&rt, &s)
 
+	// Prime externally sourced sections if so configured.
+	section.Warmup(&rt, &s)
+
+	// Take automatic backups if so configured.
+	backup.Schedule(&rt, &s)
+
 	// Start web server.
 	ready := make(chan struct{}, 1) // channel signals router ready
-	server.Start(&rt, &s, ready)
+	server.Start(&rt, &s, ready, stop)
+}
+
+// runCommand runs backup or restore command, exiting with
+// non-zero status on failure.
+// Interrupting command cancels it so that partial files are removed.
+func runCommand(rt *env.Runtime, s *store.Store, cmd *backup.Command, stop context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop()
+	}()
+
+	err := cmd.Run(rt, s, os.Stdin, os.Stdout)
+	stop()
+	if rt.Db != nil {
+		rt.Db.Close()
+	}
+	if err != nil {
+		rt.Log.Error(cmd.Name+" failed", err)
+		os.Exit(1)
+	}
 }
@@ -15,6 +15,7 @@ package logging
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
@@ -70,9 +71,13 @@ func (l Logger) SetDB(logger env.Logger, db *sqlx.DB) env.Logger {
 
 // NewLogger returns initialized logging instance.
 func NewLogger(trace bool) env.Logger {
-	l := log.New(os.Stdout, "", 0)
-	l.SetOutput(os.Stdout)
-	// log.SetOutput(os.Stdout)
+	return NewWriterLogger(os.Stdout, trace)
+}
+
+// NewWriterLogger returns logging instance that writes to w,
+// e.g. stderr when stdout carries command output.
+func NewWriterLogger(w io.Writer, trace bool) env.Logger {
+	l := log.New(w, "", 0)
 
 	var logger Logger
 	logger.log = l
@@ -23,6 +23,7 @@ import (
 	activity "github.com/documize/community/domain/activity"
 	attachment "github.com/documize/community/domain/attachment"
 	audit "github.com/documize/community/domain/audit"
+	backup "github.com/documize/community/domain/backup"
 	block "github.com/documize/community/domain/block"
 	category "github.com/documize/community/domain/category"
 	document "github.com/documize/community/domain/document"
@@ -43,6 +44,12 @@ import (
 	_ "github.com/go-sql-driver/mysql" // the mysql driver is required behind the scenes
 )
 
+func init() {
+	Register("mysql", SetMySQLProvider)
+	Register("mariadb", SetMySQLProvider)
+	Register("percona", SetMySQLProvider)
+}
+
 // SetMySQLProvider creates MySQL provider
 func SetMySQLProvider(r *env.Runtime, s *store.Store) {
 	// Set up provider specific details.
@@ -82,6 +89,11 @@ func SetMySQLProvider(r *env.Runtime, s *store.Store) {
 	auditStore.Runtime = r
 	s.Audit = auditStore
 
+	// Backup
+	backupStore := backup.Store{}
+	backupStore.Runtime = r
+	s.Backup = backupStore
+
 	// (Block) Section Template
 	blockStore := block.Store{}
 	blockStore.Runtime = r
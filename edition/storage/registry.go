@@ -0,0 +1,76 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/documize/community/core/env"
+	"github.com/documize/community/domain/store"
+)
+
+// Factory sets up storage provider and data stores for given runtime.
+type Factory func(r *env.Runtime, s *store.Store)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Factory)
+)
+
+// Register makes storage provider available under given database type name.
+// Providers register themselves in init() so that boot code
+// does not need to know about them.
+// Register panics if name is blank, factory is nil or name is already taken.
+func Register(name string, factory Factory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if len(name) == 0 {
+		panic("storage: Register provider name is blank")
+	}
+	if factory == nil {
+		panic("storage: Register provider factory is nil for " + name)
+	}
+	if _, dup := providers[name]; dup {
+		panic("storage: Register called twice for provider " + name)
+	}
+
+	providers[name] = factory
+}
+
+// Lookup returns storage provider factory registered under name.
+func Lookup(name string) (Factory, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	f, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %s", name)
+	}
+
+	return f, nil
+}
+
+// Names returns sorted list of registered database type names.
+func Names() (names []string) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	for n := range providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return
+}
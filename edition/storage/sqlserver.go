@@ -13,7 +13,9 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 
 	_ "github.com/denisenkom/go-mssqldb" // the SQL Server driver is required behind the scenes
@@ -22,6 +24,7 @@ import (
 	activity "github.com/documize/community/domain/activity"
 	attachment "github.com/documize/community/domain/attachment"
 	audit "github.com/documize/community/domain/audit"
+	backup "github.com/documize/community/domain/backup"
 	block "github.com/documize/community/domain/block"
 	category "github.com/documize/community/domain/category"
 	document "github.com/documize/community/domain/document"
@@ -50,6 +53,10 @@ type SQLServerProvider struct {
 	Variant env.StoreType
 }
 
+func init() {
+	Register("sqlserver", SetSQLServerProvider)
+}
+
 // SetSQLServerProvider creates PostgreSQL provider.
 //
 // Useful links:
@@ -92,6 +99,11 @@ func SetSQLServerProvider(r *env.Runtime, s *store.Store) {
 	auditStore.Runtime = r
 	s.Audit = auditStore
 
+	// Backup
+	backupStore := backup.Store{}
+	backupStore.Runtime = r
+	s.Backup = backupStore
+
 	// Section Template
 	blockStore := block.Store{}
 	blockStore.Runtime = r
@@ -226,6 +238,29 @@ func (p SQLServerProvider) DatabaseName() string {
 	return ""
 }
 
+// CheckConnectionString reports connection strings lacking
+// the fields needed to reach SQL Server.
+func (p SQLServerProvider) CheckConnectionString() error {
+	u, err := url.Parse(p.ConnectionString)
+	if err != nil {
+		return fmt.Errorf("invalid SQL Server connection string: %s", err)
+	}
+	if u.Scheme != "sqlserver" {
+		return errors.New("SQL Server connection string must start with sqlserver://")
+	}
+	if len(u.Hostname()) == 0 {
+		return errors.New("SQL Server connection string is missing host")
+	}
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return errors.New("SQL Server connection string is missing username")
+	}
+	if len(p.DatabaseName()) == 0 {
+		return errors.New("SQL Server connection string is missing database parameter")
+	}
+
+	return nil
+}
+
 // MakeConnectionString returns provider specific DB connection string
 // complete with default parameters.
 func (p SQLServerProvider) MakeConnectionString() string {
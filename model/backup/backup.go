@@ -45,6 +45,50 @@ type Manifest struct {
 
 	// Database provider used by source system.
 	StoreType env.StoreType `json:"storeType"`
+
+	// Schema version of source database, specific to StoreType.
+	SchemaVersion int `json:"schemaVersion"`
+
+	// Tables that were intentionally left out of the backup.
+	Excluded []string `json:"excluded"`
+
+	// Free-form labels attached at backup time (e.g. environment, purpose).
+	Labels map[string]string `json:"labels"`
+
+	// Number of rows exported per table.
+	Counts map[string]int `json:"counts"`
+
+	// Files held in archive, other than manifest itself.
+	// Older backups have none.
+	Files []ManifestFile `json:"files"`
+
+	// Options backup was taken with, absent from older backups.
+	Spec *ExportSpec `json:"spec,omitempty"`
+
+	// Date range used to select documents, zero values mean unbounded.
+	DateFrom time.Time `json:"dateFrom"`
+	DateTo   time.Time `json:"dateTo"`
+
+	// Space that backup was limited to, if any.
+	SpaceID string `json:"spaceId"`
+}
+
+// ManifestFile describes file held in backup archive.
+type ManifestFile struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"sha256"`
+}
+
+// IsExcluded returns true if table was intentionally left out of backup.
+func (m *Manifest) IsExcluded(table string) bool {
+	for _, t := range m.Excluded {
+		if t == table {
+			return true
+		}
+	}
+
+	return false
 }
 
 // ExportSpec controls what data is exported to the backup file.
@@ -54,8 +98,140 @@ type ExportSpec struct {
 	OrgID string `json:"org"`
 
 	// Retain will keep the backup file on disk after operation is complete.
-	// File is located in the same folder as the running executable.
+	// File is located in the configured backup temp folder.
 	Retain bool `json:"retain"`
+
+	// ExcludeTables lists database tables to skip (e.g. dmz_doc_attachment).
+	// Allows partial backup when a table is corrupt or otherwise unreadable.
+	ExcludeTables []string `json:"excludeTables"`
+
+	// MaxArchiveBytes aborts backup once archive grows beyond this size.
+	// Protects disk space against unexpectedly large backups.
+	// Zero means no limit.
+	MaxArchiveBytes int64 `json:"maxArchiveBytes"`
+
+	// Verify re-reads the finished archive and checks that every table
+	// decodes and holds the same number of rows as the database.
+	// Backup fails if verification fails.
+	Verify bool `json:"verify"`
+
+	// Anonymize replaces personal data with deterministic pseudonyms
+	// so that relationships between records still hold:
+	//   - user first name, last name, initials and email (pseudonym keyed by user ID)
+	//   - user password hash, salt and reset token are removed
+	//   - comment and share emails (pseudonym keyed by email), share messages removed
+	//   - audit log IP addresses are removed
+	Anonymize bool `json:"anonymize"`
+
+	// AnonymizeContent additionally scrubs document content:
+	// section and revision bodies, section raw data,
+	// comment text and attachment file data.
+	// Document, space and category names are retained.
+	AnonymizeContent bool `json:"anonymizeContent"`
+
+	// IncludeAudit adds the audit log to the backup, limited by
+	// DateFrom and DateTo when set. Off by default because the audit log
+	// grows with every login and document view and can dwarf content.
+	// Audit entries hold user IDs and client IP addresses;
+	// Anonymize removes IP addresses but user IDs remain.
+	IncludeAudit bool `json:"includeAudit"`
+
+	// SplitSizeBytes breaks the archive into numbered parts no larger
+	// than this size (e.g. for transfer tools with file size caps).
+	// Parts are written alongside a part manifest and kept in the
+	// backup temp folder. Zero means single archive, otherwise
+	// parts must be at least 1 MiB.
+	SplitSizeBytes int64 `json:"splitSizeBytes"`
+
+	// Labels are free-form key/value pairs recorded in the manifest
+	// so that archives can be filtered programmatically.
+	Labels map[string]string `json:"labels"`
+
+	// DateFrom and DateTo limit documents and their sections to those
+	// created or revised within the range (e.g. legal hold exports).
+	// Attachments, comments and other dependencies of selected documents
+	// are always included. Zero values mean unbounded.
+	DateFrom time.Time `json:"dateFrom"`
+	DateTo   time.Time `json:"dateTo"`
+
+	// SpaceID limits backup to one space: its documents and their
+	// dependencies, categories, templates, pins and permissions.
+	// Organization, and the users and groups granted access to the space,
+	// are included so that references resolve on restore. Organization
+	// sign-in configuration, license and user passwords are left out.
+	// Space owners can download backup of their own space without being
+	// administrators, server side options remain reserved for administrators.
+	SpaceID string `json:"spaceId"`
+
+	// Passphrase encrypts archive using AES-256-GCM when set.
+	// Same passphrase is needed to restore, there is no way to recover it.
+	Passphrase string `json:"passphrase"`
+
+	// PassphraseRef fetches passphrase from credential provider instead,
+	// e.g. 'file:/run/secrets/backup_key' or 'env:BACKUP_KEY', so that
+	// automated backups never handle the key directly.
+	// Only administrators can use it.
+	PassphraseRef string `json:"passphraseRef"`
+
+	// Destination uploads finished archive to S3-compatible object storage
+	// instead of sending it to the caller.
+	Destination *Destination `json:"destination"`
+}
+
+// Destination describes S3-compatible bucket that receives backups.
+type Destination struct {
+	// Endpoint is base URL of object store (e.g. https://minio.example.com:9000).
+	// Defaults to AWS S3 for region when empty.
+	Endpoint string `json:"endpoint"`
+
+	// Region defaults to us-east-1, which MinIO accepts by default.
+	Region string `json:"region"`
+
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to archive name to form object key (e.g. nightly/).
+	Prefix string `json:"prefix"`
+
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+
+	// PathStyle addresses bucket as part of path rather than host name,
+	// as needed by most MinIO and other self-hosted deployments.
+	PathStyle bool `json:"pathStyle"`
+}
+
+// SpaceExcluded lists organization wide tables left out of space backups.
+var SpaceExcluded = []string{"dmz_user_config", "dmz_audit_log"}
+
+// SpaceBackup returns true if backup is limited to one space.
+func (e *ExportSpec) SpaceBackup() bool {
+	return len(e.SpaceID) > 0
+}
+
+// DateBounded returns true if documents are limited to date range.
+func (e *ExportSpec) DateBounded() bool {
+	return !e.DateFrom.IsZero() || !e.DateTo.IsZero()
+}
+
+// IsExcluded returns true if table is to be left out of backup.
+func (e *ExportSpec) IsExcluded(table string) bool {
+	for _, t := range e.Excluded() {
+		if t == table {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Excluded returns tables left out of backup, including
+// those that never form part of space backups.
+func (e *ExportSpec) Excluded() []string {
+	if !e.SpaceBackup() {
+		return e.ExcludeTables
+	}
+
+	return append(append([]string{}, e.ExcludeTables...), SpaceExcluded...)
 }
 
 // SystemBackup happens if org ID is "*".
@@ -68,6 +244,43 @@ type ImportSpec struct {
 	// Overwrite current organization settings.
 	OverwriteOrg bool `json:"overwriteOrg"`
 
+	// Merge applies backup rows only when newer than existing rows.
+	// Existing data that is newer or absent from the backup is left untouched.
+	Merge bool `json:"merge"`
+
+	// MatchEmail maps incoming users onto existing users with the same
+	// email address (case-insensitive) instead of replacing all users.
+	// Always in effect for tenant restores.
+	MatchEmail bool `json:"matchEmail"`
+
+	// RenameConflicts gives incoming spaces and categories a new name
+	// when their slug clashes with existing rows during merge restore.
+	// Without it such clashes abort restore before any data is changed.
+	RenameConflicts bool `json:"renameConflicts"`
+
+	// Resume skips tables already restored by a previous failed attempt
+	// using the same backup file.
+	Resume bool `json:"resume"`
+
+	// IncludeAudit restores audit log when present in backup file.
+	// Otherwise the existing audit log is kept.
+	IncludeAudit bool `json:"includeAudit"`
+
+	// BatchSize is number of rows grouped into each restore INSERT.
+	// Zero uses a default suited to the database provider.
+	BatchSize int `json:"batchSize"`
+
+	// IDMode is either IDModePreserve (default) or IDModeRegenerate.
+	// Regenerating gives restored entities new IDs so that the same
+	// backup can be restored into several tenants side by side.
+	IDMode string `json:"idMode"`
+
+	// Passphrase opens encrypted backup files.
+	Passphrase string `json:"passphrase"`
+
+	// PassphraseRef fetches passphrase from credential provider instead.
+	PassphraseRef string `json:"passphraseRef"`
+
 	// As found in backup file.
 	Manifest Manifest
 
@@ -79,6 +292,15 @@ type ImportSpec struct {
 	GlobalBackup bool
 }
 
+// Restore ID handling modes.
+const (
+	// IDModePreserve keeps entity IDs found in backup file.
+	IDModePreserve = "preserve"
+
+	// IDModeRegenerate gives every restored entity a new ID.
+	IDModeRegenerate = "regenerate"
+)
+
 // User represents user object for backup/restore operations.
 // We include user specific secrets in such operations.
 type User struct {
@@ -95,3 +317,35 @@ type User struct {
 	LastVersion string `json:"lastVersion"`
 	Locale      string `json:"locale"`
 }
+
+// PartManifest describes archive split into parts.
+// Parts are joined in listed order to recreate the archive.
+type PartManifest struct {
+	ID       string `json:"id"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"` // SHA-256 of complete archive
+	Parts    []Part `json:"parts"`
+}
+
+// Part is one piece of split archive.
+type Part struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"` // SHA-256 of part
+}
+
+// Upload describes backup sent to destination object store.
+type Upload struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+// Estimate describes backup that would be produced for given spec.
+// Returned by dry run backups; no archive is written.
+type Estimate struct {
+	Tables      int            `json:"tables"`
+	Rows        int            `json:"rows"`
+	Counts      map[string]int `json:"counts"`
+	ApproxBytes int64          `json:"approxBytes"`
+	Warnings    []string       `json:"warnings"`
+}
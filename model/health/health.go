@@ -0,0 +1,34 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package health
+
+// Status values reported by health checks.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+)
+
+// Site modes reported by health checks.
+const (
+	SiteModeNormal  = "normal"
+	SiteModeOffline = "offline"
+	SiteModeSetup   = "setup"
+	SiteModeBadDB   = "baddb"
+)
+
+// Report describes instance health as seen by load balancers
+// and orchestration platforms.
+type Report struct {
+	Status   string `json:"status"`
+	SiteMode string `json:"siteMode"`
+	Database string `json:"database,omitempty"` // ok, unavailable or not connected
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/documize/community/domain/conversion"
 	"github.com/documize/community/domain/document"
 	"github.com/documize/community/domain/group"
+	"github.com/documize/community/domain/health"
 	"github.com/documize/community/domain/label"
 	"github.com/documize/community/domain/link"
 	"github.com/documize/community/domain/meta"
@@ -74,6 +75,7 @@ func RegisterEndpoints(rt *env.Runtime, s *store.Store) {
 	conversion := conversion.Handler{Runtime: rt, Store: s, Indexer: indexer}
 	permission := permission.Handler{Runtime: rt, Store: s}
 	organization := organization.Handler{Runtime: rt, Store: s}
+	health := health.Handler{Runtime: rt, Store: s}
 
 	searchEndpoint := search.Handler{Runtime: rt, Store: s, Indexer: indexer}
 	onboardEndpoint := onboard.Handler{Runtime: rt, Store: s, Indexer: indexer}
@@ -190,6 +192,7 @@ func RegisterEndpoints(rt *env.Runtime, s *store.Store) {
 	AddPrivate(rt, "sections", []string{"GET", "OPTIONS"}, nil, section.GetSections)
 	AddPrivate(rt, "sections", []string{"POST", "OPTIONS"}, nil, section.RunSectionCommand)
 	AddPrivate(rt, "sections/refresh", []string{"GET", "OPTIONS"}, nil, section.RefreshSections)
+	AddPrivate(rt, "sections/{sectionID}/refresh", []string{"POST", "OPTIONS"}, nil, section.RefreshSection)
 	AddPrivate(rt, "sections/blocks/space/{spaceID}", []string{"GET", "OPTIONS"}, nil, block.GetBySpace)
 	AddPrivate(rt, "sections/blocks/{blockID}", []string{"GET", "OPTIONS"}, nil, block.Get)
 	AddPrivate(rt, "sections/blocks/{blockID}", []string{"PUT", "OPTIONS"}, nil, block.Update)
@@ -241,6 +244,8 @@ func RegisterEndpoints(rt *env.Runtime, s *store.Store) {
 	AddPrivate(rt, "global/ldap/sync", []string{"GET", "OPTIONS"}, nil, ldap.Sync)
 	AddPrivate(rt, "global/backup", []string{"POST", "OPTIONS"}, nil, backup.Backup)
 	AddPrivate(rt, "global/restore", []string{"POST", "OPTIONS"}, nil, backup.Restore)
+	AddPrivate(rt, "global/backup/progress", []string{"GET", "OPTIONS"}, nil, backup.BackupProgress)
+	AddPrivate(rt, "global/backup/inspect", []string{"POST", "OPTIONS"}, nil, backup.Inspect)
 	AddPrivate(rt, "global/search/status", []string{"GET", "OPTIONS"}, nil, searchEndpoint.Status)
 	AddPrivate(rt, "global/search/reindex", []string{"POST", "OPTIONS"}, nil, searchEndpoint.Reindex)
 
@@ -248,6 +253,8 @@ func RegisterEndpoints(rt *env.Runtime, s *store.Store) {
 
 	Add(rt, RoutePrefixRoot, "robots.txt", []string{"GET", "OPTIONS"}, nil, meta.RobotsTxt)
 	Add(rt, RoutePrefixRoot, "sitemap.xml", []string{"GET", "OPTIONS"}, nil, meta.Sitemap)
+	Add(rt, RoutePrefixRoot, "healthz", []string{"GET", "HEAD"}, nil, health.Live)
+	Add(rt, RoutePrefixRoot, "readyz", []string{"GET", "HEAD"}, nil, health.Ready)
 
 	webHandler := web.Handler{Runtime: rt, Store: s}
 	Add(rt, RoutePrefixRoot, "{rest:.*}", nil, nil, webHandler.EmberHandler)
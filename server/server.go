@@ -12,6 +12,7 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net/http"
@@ -31,7 +32,8 @@ import (
 var testHost string // used during automated testing
 
 // Start router to handle all HTTP traffic.
-func Start(rt *env.Runtime, s *store.Store, ready chan struct{}) {
+// Returns once server has shut down, calling stop to cancel runtime shutdown context.
+func Start(rt *env.Runtime, s *store.Store, ready chan struct{}, stop context.CancelFunc) {
 	// decide which mode to serve up
 	switch rt.Flags.SiteMode {
 	case env.SiteModeOffline:
@@ -103,7 +105,8 @@ func Start(rt *env.Runtime, s *store.Store, ready chan struct{}) {
 			rt.Log.Info("***")
 		}
 
-		n.Run(testHost + ":" + rt.Flags.HTTPPort)
+		server := &http.Server{Addr: testHost + ":" + rt.Flags.HTTPPort, Handler: n}
+		serve(rt, server, server.ListenAndServe, stop)
 	} else {
 		if rt.Flags.ForceHTTPPort2SSL != "" {
 			rt.Log.Info("Web Server: binding non-SSL server on " + rt.Flags.ForceHTTPPort2SSL + " and redirecting to SSL server on " + rt.Flags.HTTPPort)
@@ -136,8 +139,8 @@ func Start(rt *env.Runtime, s *store.Store, ready chan struct{}) {
 		server := &http.Server{Addr: ":" + rt.Flags.HTTPPort, Handler: n, TLSConfig: cfg}
 		server.SetKeepAlivesEnabled(true)
 
-		if err := server.ListenAndServeTLS(rt.Flags.SSLCertFile, rt.Flags.SSLKeyFile); err != nil {
-			rt.Log.Error("ListenAndServeTLS on "+rt.Flags.HTTPPort, err)
-		}
+		serve(rt, server, func() error {
+			return server.ListenAndServeTLS(rt.Flags.SSLCertFile, rt.Flags.SSLKeyFile)
+		}, stop)
 	}
 }
@@ -0,0 +1,99 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/documize/community/core/env"
+)
+
+const shutdownGraceDefault = 30 * time.Second
+
+// shutdownGrace reads time allowed for in-flight requests to finish,
+// using default for missing or invalid value.
+func shutdownGrace(f env.Flags) time.Duration {
+	if d, err := time.ParseDuration(f.ShutdownGrace); err == nil && d > 0 {
+		return d
+	}
+
+	return shutdownGraceDefault
+}
+
+// serve runs web server until it fails or process is asked to stop
+// (SIGINT or SIGTERM).
+func serve(rt *env.Runtime, srv *http.Server, listen func() error, stop context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	run(rt, srv, listen, signals, stop)
+}
+
+// run serves requests until signalled, then stops accepting new
+// requests and allows in-flight requests grace period to finish.
+// Requests still running after that, along with other long running work,
+// are cancelled through runtime shutdown context.
+// Database is closed last.
+func run(rt *env.Runtime, srv *http.Server, listen func() error, signals <-chan os.Signal, stop context.CancelFunc) {
+	// Request contexts derive from runtime shutdown context.
+	if rt.Shutdown != nil {
+		srv.BaseContext = func(net.Listener) context.Context { return rt.Shutdown }
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- listen()
+	}()
+
+	select {
+	case err := <-failed:
+		if err != nil && err != http.ErrServerClosed {
+			rt.Log.Error("Web server stopped", err)
+		}
+		stop()
+		closeDB(rt)
+		return
+	case sig := <-signals:
+		rt.Log.Info(fmt.Sprintf("Web server received %s, shutting down", sig))
+	}
+
+	grace := shutdownGrace(rt.Flags)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	stop()
+	if err != nil {
+		rt.Log.Info(fmt.Sprintf("Web server shutdown grace period of %s expired, cancelling remaining requests", grace))
+		srv.Close()
+	}
+
+	closeDB(rt)
+	rt.Log.Info("Web server shutdown complete")
+}
+
+func closeDB(rt *env.Runtime) {
+	if rt.Db == nil {
+		return
+	}
+	if err := rt.Db.Close(); err != nil {
+		rt.Log.Error("Unable to close database", err)
+	}
+}
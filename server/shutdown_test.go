@@ -0,0 +1,167 @@
+// Copyright 2016 Documize Inc. <legal@documize.com>. All rights reserved.
+//
+// This software (Documize Community Edition) is licensed under
+// GNU AGPL v3 http://www.gnu.org/licenses/agpl-3.0.en.html
+//
+// You can operate outside the AGPL restrictions by purchasing
+// Documize Enterprise Edition and obtaining a commercial license
+// by contacting <sales@documize.com>.
+//
+// https://documize.com
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/documize/community/core/env"
+)
+
+type testLogger struct {
+	sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) Info(message string) {
+	l.Lock()
+	defer l.Unlock()
+	l.messages = append(l.messages, message)
+}
+func (l *testLogger) Infof(message string, a ...interface{}) { l.Info(fmt.Sprintf(message, a...)) }
+func (l *testLogger) Trace(message string)                   {}
+func (l *testLogger) Error(message string, err error)        { l.Info(message) }
+
+func (l *testLogger) logged(s string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, m := range l.messages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// startTest serves handler through run and returns address,
+// signal channel and channel closed once run returns.
+func startTest(t *testing.T, rt *env.Runtime, h http.Handler, stop context.CancelFunc) (string, chan os.Signal, chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: h}
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		run(rt, srv, func() error { return srv.Serve(ln) }, signals, stop)
+		close(done)
+	}()
+
+	return "http://" + ln.Addr().String(), signals, done
+}
+
+// In-flight request completes before server stops.
+func TestShutdownDrains(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	rt := &env.Runtime{Log: &testLogger{}, Flags: env.Flags{ShutdownGrace: "5s"}, Shutdown: ctx}
+
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	addr, signals, done := startTest(t, rt, h, stop)
+
+	type result struct {
+		body string
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(addr)
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		res <- result{body: string(b), err: err}
+	}()
+
+	<-started
+	signals <- syscall.SIGTERM
+
+	r := <-res
+	if r.err != nil || r.body != "done" {
+		t.Errorf("expected in-flight request to complete got %q %v", r.body, r.err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	if ctx.Err() == nil {
+		t.Error("expected shutdown context cancelled")
+	}
+
+	// New requests are refused.
+	if _, err := http.Get(addr); err == nil {
+		t.Error("expected request after shutdown to fail")
+	}
+}
+
+// Request outliving grace period sees its context cancelled.
+func TestShutdownGraceExpired(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	log := &testLogger{}
+	rt := &env.Runtime{Log: log, Flags: env.Flags{ShutdownGrace: "100ms"}, Shutdown: ctx}
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	addr, signals, done := startTest(t, rt, h, stop)
+
+	go http.Get(addr)
+	<-started
+	signals <- os.Interrupt
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("long running request was not cancelled")
+	}
+	<-done
+
+	if !log.logged("grace period of 100ms expired") {
+		t.Errorf("expected grace period expiry to be logged %v", log.messages)
+	}
+}
+
+func TestShutdownGrace(t *testing.T) {
+	if d := shutdownGrace(env.Flags{}); d != shutdownGraceDefault {
+		t.Errorf("expected default got %s", d)
+	}
+	if d := shutdownGrace(env.Flags{ShutdownGrace: "1m"}); d != time.Minute {
+		t.Errorf("expected 1m got %s", d)
+	}
+	if d := shutdownGrace(env.Flags{ShutdownGrace: "bad"}); d != shutdownGraceDefault {
+		t.Errorf("expected default got %s", d)
+	}
+}